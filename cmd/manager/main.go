@@ -5,29 +5,49 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/controller"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/database"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
+	"k8s.io/apimachinery/pkg/types"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
 const (
 	metricsHost                                  = "0.0.0.0"
 	metricsPort                            int32 = 8384
+	defaultHealthProbePort                       = 8385
+	defaultStuckReconcileTimeout                 = 10 * time.Minute
+	defaultDatabaseConnectionMaxWait             = 5 * time.Minute
+	initialDatabaseConnectionRetryDelay          = time.Second
+	maxDatabaseConnectionRetryDelay              = 30 * time.Second
+	defaultDatabaseCredentialsPollPeriod         = 30 * time.Second
 	environmentVariableControllerNamespace       = "POD_NAMESPACE"
 	environmentVariableDatabaseURL               = "DATABASE_URL"
 	environmentVariableWatchNamespace            = "WATCH_NAMESPACE"
+	defaultTombstoneCollectionPeriod             = time.Hour
+	migrateSubcommand                            = "migrate"
+)
+
+var (
+	errInvalidTombstoneRetentionPerType = errors.New("invalid tombstone retention per type, expected type=duration")
+	errUnknownSubcommand                = errors.New("unknown subcommand, the only subcommand is " + migrateSubcommand)
 )
 
 func printVersion(log logr.Logger) {
@@ -41,6 +61,44 @@ func doMain() int {
 		Development: true,
 	}
 	opts.BindFlags(flag.CommandLine)
+
+	configFile := flag.String("config", "", "The path of a YAML configuration file of the types to sync.")
+	enabledTypes := flag.String("enabled-types", "",
+		"Comma-separated names of the types to sync, e.g. policies,placementbindings,placementrules. "+
+			"Overrides the enabled types of the configuration file. All the types are synced by default.")
+	databaseSchema := flag.String("database-schema", "",
+		"The database schema of the tables, overrides the schema of the configuration file. spec by default.")
+	tombstoneRetention := flag.Duration("tombstone-retention", 0,
+		"How long rows marked as deleted are kept before they are hard-deleted, 0 keeps them forever.")
+	tombstoneRetentionPerType := flag.String("tombstone-retention-per-type", "",
+		"Comma-separated type=duration pairs that override the tombstone retention per type, "+
+			"e.g. policies=72h,subscriptions=24h.")
+	tombstoneCollectionPeriod := flag.Duration("tombstone-collection-period", defaultTombstoneCollectionPeriod,
+		"The period of the hard-deletion of the rows marked as deleted.")
+	tombstoneAcknowledgementRequired := flag.Bool("tombstone-acknowledgement-required", false,
+		"Keep the rows marked as deleted until all the consumers of their table have acknowledged their version "+
+			"in the <table>_acknowledgements table.")
+
+	healthProbePort := flag.Int("health-probe-port", defaultHealthProbePort,
+		"The port of the /healthz and /readyz probe endpoints.")
+	stuckReconcileTimeout := flag.Duration("stuck-reconcile-timeout", defaultStuckReconcileTimeout,
+		"How long a reconcile may run before the liveness probe fails.")
+	databaseURLFile := flag.String("database-url-file", "",
+		"The path of a file that contains the database URL, overrides the DATABASE_URL environment variable.")
+	databasePasswordFile := flag.String("database-password-file", "",
+		"The path of a file that contains the database password, overrides the password of the database URL.")
+	databaseSecret := flag.String("database-secret", "",
+		"The name of a secret in the namespace of the syncer that contains the database URL or password.")
+	databaseSecretURLKey := flag.String("database-secret-url-key", "",
+		"The key of the database URL in the database secret.")
+	databaseSecretPasswordKey := flag.String("database-secret-password-key", "",
+		"The key of the database password in the database secret.")
+	databaseCredentialsPollPeriod := flag.Duration("database-credentials-poll-period",
+		defaultDatabaseCredentialsPollPeriod,
+		"The period of the checks of the database URL and password files and secret for changes.")
+	databaseConnectionMaxWait := flag.Duration("database-connection-max-wait", defaultDatabaseConnectionMaxWait,
+		"How long to retry connecting to the database on startup before exiting.")
+
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
@@ -48,41 +106,143 @@ func doMain() int {
 
 	printVersion(log)
 
-	leaderElectionNamespace, found := os.LookupEnv(environmentVariableControllerNamespace)
-	if !found {
-		log.Error(nil, "Not found:", "environment variable", environmentVariableControllerNamespace)
+	if flag.NArg() > 1 || (flag.NArg() == 1 && flag.Arg(0) != migrateSubcommand) {
+		log.Error(errUnknownSubcommand, "Invalid arguments", "arguments", flag.Args())
 		return 1
 	}
 
-	namespace, found := os.LookupEnv(environmentVariableWatchNamespace)
-	if !found {
-		log.Error(nil, "Failed to get watch namespace")
+	parsedTombstoneRetentionPerType, err := parseTombstoneRetentionPerType(*tombstoneRetentionPerType)
+	if err != nil {
+		log.Error(err, "Failed to parse flag", "flag", "tombstone-retention-per-type")
 		return 1
 	}
 
-	databaseURL, found := os.LookupEnv(environmentVariableDatabaseURL)
-	if !found {
-		log.Error(nil, "Not found:", "environment variable", environmentVariableDatabaseURL)
+	controllersConfig := &controller.Config{}
+
+	if *configFile != "" {
+		if err := controller.LoadConfigFile(*configFile, controllersConfig); err != nil {
+			log.Error(err, "Failed to load the configuration file", "path", *configFile)
+			return 1
+		}
+	}
+
+	if *enabledTypes != "" {
+		controllersConfig.EnabledTypes = parseEnabledTypes(*enabledTypes)
+	}
+
+	if *databaseSchema != "" {
+		controllersConfig.Schema = *databaseSchema
+	}
+
+	controllersConfig.TombstoneRetention = *tombstoneRetention
+	controllersConfig.TombstoneRetentionPerType = parsedTombstoneRetentionPerType
+	controllersConfig.TombstoneCollectionPeriod = *tombstoneCollectionPeriod
+	controllersConfig.TombstoneAcknowledgementRequired = *tombstoneAcknowledgementRequired
+
+	if err := controller.ValidateConfig(controllersConfig); err != nil {
+		log.Error(err, "Invalid configuration")
 		return 1
 	}
 
-	// when switched to controller runtime 0.7, use the context returned by ctrl.SetupSignalHandler()
-	dbConnectionPool, err := pgxpool.Connect(context.TODO(), databaseURL)
+	credentialsSource := &database.CredentialsSource{
+		URL:               os.Getenv(environmentVariableDatabaseURL),
+		URLFile:           *databaseURLFile,
+		PasswordFile:      *databasePasswordFile,
+		SecretURLKey:      *databaseSecretURLKey,
+		SecretPasswordKey: *databaseSecretPasswordKey,
+	}
+
+	if *databaseSecret != "" {
+		// the secret is in the namespace of the syncer
+		secretNamespace, found := os.LookupEnv(environmentVariableControllerNamespace)
+		if !found {
+			log.Error(nil, "Not found:", "environment variable", environmentVariableControllerNamespace)
+			return 1
+		}
+
+		credentialsSource.Secret = types.NamespacedName{Namespace: secretNamespace, Name: *databaseSecret}
+
+		if credentialsSource.Client, err = client.New(ctrl.GetConfigOrDie(), client.Options{}); err != nil {
+			log.Error(err, "Failed to create a client to read the database secret")
+			return 1
+		}
+	}
+
+	ctx := ctrl.SetupSignalHandler()
+
+	dbConnectionPool, databaseCredentials, err := connectToDatabase(ctx, log, credentialsSource,
+		*databaseConnectionMaxWait)
 	if err != nil {
 		log.Error(err, "Failed to connect to the database")
 		return 1
 	}
 	defer dbConnectionPool.Close()
 
-	mgr, err := createManager(leaderElectionNamespace, namespace, metricsHost, metricsPort, dbConnectionPool)
+	// the migrate subcommand migrates the tables of the enabled types and exits, it does not need the namespaces of
+	// the syncer, since it only accesses the database, and the database secret if set
+	if flag.Arg(0) == migrateSubcommand {
+		if err := database.Migrate(ctx, dbConnectionPool, controller.DatabaseSchema(controllersConfig),
+			controller.EnabledTableNames(controllersConfig), ctrl.Log.WithName("migrate")); err != nil {
+			log.Error(err, "Failed to migrate the database")
+			return 1
+		}
+
+		log.Info("Migrated the database")
+
+		return 0
+	}
+
+	if err := database.CheckSchema(ctx, dbConnectionPool, controller.DatabaseSchema(controllersConfig),
+		controller.EnabledTableNames(controllersConfig)); err != nil {
+		log.Error(err, "Unsupported database schema")
+		return 1
+	}
+
+	var runnables []manager.Runnable
+
+	// the credentials can only change if they are read from a file or a secret
+	if *databaseURLFile != "" || *databasePasswordFile != "" || *databaseSecret != "" {
+		runnables = append(runnables, database.NewCredentialsWatcher(dbConnectionPool, credentialsSource,
+			databaseCredentials, *databaseCredentialsPollPeriod, ctrl.Log.WithName("database-credentials-watcher")))
+	}
+
+	return runManager(ctx, log, specstore.NewPostgresSpecStore(dbConnectionPool), controllersConfig,
+		int32(*healthProbePort), *stuckReconcileTimeout, runnables...)
+}
+
+// runManager runs the controllers of the spec store, with the given additional runnables, until the context is done.
+func runManager(ctx context.Context, log logr.Logger, specStore specstore.SpecStore,
+	controllersConfig *controller.Config, healthProbePort int32, stuckReconcileTimeout time.Duration,
+	runnables ...manager.Runnable) int {
+	leaderElectionNamespace, found := os.LookupEnv(environmentVariableControllerNamespace)
+	if !found {
+		log.Error(nil, "Not found:", "environment variable", environmentVariableControllerNamespace)
+		return 1
+	}
+
+	namespace, found := os.LookupEnv(environmentVariableWatchNamespace)
+	if !found {
+		log.Error(nil, "Failed to get watch namespace")
+		return 1
+	}
+
+	mgr, err := createManager(leaderElectionNamespace, namespace, metricsHost, metricsPort, healthProbePort,
+		specStore, controllersConfig, stuckReconcileTimeout)
 	if err != nil {
 		log.Error(err, "Failed to create manager")
 		return 1
 	}
 
+	for _, runnable := range runnables {
+		if err := mgr.Add(runnable); err != nil {
+			log.Error(err, "Failed to add a runnable to the manager")
+			return 1
+		}
+	}
+
 	log.Info("Starting the Cmd.")
 
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(ctx); err != nil {
 		log.Error(err, "Manager exited non-zero")
 		return 1
 	}
@@ -90,11 +250,13 @@ func doMain() int {
 	return 0
 }
 
-func createManager(leaderElectionNamespace, namespace, metricsHost string, metricsPort int32,
-	dbConnectionPool *pgxpool.Pool) (ctrl.Manager, error) {
+func createManager(leaderElectionNamespace, namespace, metricsHost string, metricsPort, healthProbePort int32,
+	specStore specstore.SpecStore, controllersConfig *controller.Config,
+	stuckReconcileTimeout time.Duration) (ctrl.Manager, error) {
 	options := ctrl.Options{
 		Namespace:               namespace,
 		MetricsBindAddress:      fmt.Sprintf("%s:%d", metricsHost, metricsPort),
+		HealthProbeBindAddress:  fmt.Sprintf("%s:%d", metricsHost, healthProbePort),
 		LeaderElection:          true,
 		LeaderElectionNamespace: leaderElectionNamespace,
 		LeaderElectionID:        "hub-of-hubs-spec-sync-lock",
@@ -114,17 +276,99 @@ func createManager(leaderElectionNamespace, namespace, metricsHost string, metri
 		return nil, fmt.Errorf("failed to create a new manager: %w", err)
 	}
 
-	if err := controller.AddToScheme(mgr.GetScheme()); err != nil {
+	if err := controller.AddToScheme(mgr.GetScheme(), controllersConfig); err != nil {
 		return nil, fmt.Errorf("failed to add schemes: %w", err)
 	}
 
-	if err := controller.AddControllers(mgr, dbConnectionPool); err != nil {
+	if err := controller.AddControllers(mgr, specStore, controllersConfig); err != nil {
 		return nil, fmt.Errorf("failed to add controllers: %w", err)
 	}
 
+	if err := controller.AddHealthChecks(mgr, specStore, stuckReconcileTimeout); err != nil {
+		return nil, fmt.Errorf("failed to add health checks: %w", err)
+	}
+
 	return mgr, nil
 }
 
+// connectToDatabase connects to the database, retrying with exponential backoff until maxWait has passed, so that the
+// syncer does not crash loop while the database is starting. the credentials are read again on every retry. returns the
+// credentials used to connect.
+func connectToDatabase(ctx context.Context, log logr.Logger, credentialsSource *database.CredentialsSource,
+	maxWait time.Duration) (*database.ConnectionPool, database.Credentials, error) {
+	deadline := time.Now().Add(maxWait)
+	retryDelay := initialDatabaseConnectionRetryDelay
+
+	for {
+		credentials, err := credentialsSource.Credentials(ctx)
+		if err == nil {
+			var dbConnectionPool *pgxpool.Pool
+
+			if dbConnectionPool, err = database.Connect(ctx, credentials); err == nil {
+				return database.NewConnectionPool(dbConnectionPool), credentials, nil
+			}
+		}
+
+		if time.Now().Add(retryDelay).After(deadline) {
+			return nil, database.Credentials{}, fmt.Errorf("failed to connect to the database within %s: %w", maxWait, err)
+		}
+
+		log.Error(err, "Failed to connect to the database, retrying", "retry delay", retryDelay)
+
+		select {
+		case <-time.After(retryDelay):
+		case <-ctx.Done():
+			return nil, database.Credentials{}, fmt.Errorf("failed to connect to the database: %w", ctx.Err())
+		}
+
+		retryDelay *= 2
+		if retryDelay > maxDatabaseConnectionRetryDelay {
+			retryDelay = maxDatabaseConnectionRetryDelay
+		}
+	}
+}
+
+// parseEnabledTypes parses comma-separated type names, e.g. "policies, placementrules", ignoring empty names.
+func parseEnabledTypes(value string) []string {
+	enabledTypes := make([]string, 0)
+
+	for _, enabledType := range strings.Split(value, ",") {
+		if enabledType = strings.TrimSpace(enabledType); enabledType != "" {
+			enabledTypes = append(enabledTypes, enabledType)
+		}
+	}
+
+	return enabledTypes
+}
+
+// parseTombstoneRetentionPerType parses comma-separated type=duration pairs.
+func parseTombstoneRetentionPerType(value string) (map[string]time.Duration, error) {
+	const pairLength = 2
+
+	tombstoneRetentionPerType := make(map[string]time.Duration)
+
+	if value == "" {
+		return tombstoneRetentionPerType, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		typeAndRetention := strings.SplitN(pair, "=", pairLength)
+
+		if len(typeAndRetention) != pairLength {
+			return nil, fmt.Errorf("%w: %s", errInvalidTombstoneRetentionPerType, pair)
+		}
+
+		retention, err := time.ParseDuration(typeAndRetention[1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", errInvalidTombstoneRetentionPerType, pair, err)
+		}
+
+		tombstoneRetentionPerType[strings.TrimSpace(typeAndRetention[0])] = retention
+	}
+
+	return tombstoneRetentionPerType, nil
+}
+
 func main() {
 	os.Exit(doMain())
 }
@@ -6,29 +6,47 @@ package controller
 import (
 	"fmt"
 
-	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
 	"k8s.io/apimachinery/pkg/api/equality"
 	appsv1beta1 "sigs.k8s.io/application/api/v1beta1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
-func addApplicationController(mgr ctrl.Manager, databaseConnectionPool *pgxpool.Pool) error {
+func addApplicationController(mgr ctrl.Manager, specStore specstore.SpecStore, config *Config,
+	outageDetector *databaseOutageDetector) error {
+	reconciler := &genericSpecToDBReconciler{
+		client:             mgr.GetClient(),
+		eventRecorder:      mgr.GetEventRecorderFor(eventRecorderName),
+		specStore:          specStore,
+		log:                ctrl.Log.WithName("applications-spec-syncer"),
+		typeName:           "applications",
+		table:              config.specTable("applications"),
+		finalizerName:      hohCleanupFinalizer,
+		createInstance:     func() client.Object { return &appsv1beta1.Application{} },
+		createInstanceList: func() client.ObjectList { return &appsv1beta1.ApplicationList{} },
+		cleanStatus:        cleanApplicationStatus,
+		areEqual:           areApplicationsEqual,
+		labelSelector:      config.labelSelector("applications"),
+		namespaceFilters:   config.namespaceFilters("applications", nil),
+		resyncEvents:       make(chan event.GenericEvent),
+	}
+
 	if err := ctrl.NewControllerManagedBy(mgr).
 		For(&appsv1beta1.Application{}).
-		Complete(&genericSpecToDBReconciler{
-			client:                 mgr.GetClient(),
-			databaseConnectionPool: databaseConnectionPool,
-			log:                    ctrl.Log.WithName("applications-spec-syncer"),
-			tableName:              "applications",
-			finalizerName:          hohCleanupFinalizer,
-			createInstance:         func() client.Object { return &appsv1beta1.Application{} },
-			cleanStatus:            cleanApplicationStatus,
-			areEqual:               areApplicationsEqual,
-		}); err != nil {
+		WithEventFilter(reconciler.namespacePredicate()).
+		Watches(&source.Channel{Source: reconciler.resyncEvents}, &handler.EnqueueRequestForObject{}).
+		Complete(reconciler); err != nil {
 		return fmt.Errorf("failed to add application controller to the manager: %w", err)
 	}
 
+	if err := addDatabaseMaintainers(mgr, reconciler, config, outageDetector); err != nil {
+		return fmt.Errorf("failed to add application database maintainers to the manager: %w", err)
+	}
+
 	return nil
 }
 
@@ -6,33 +6,53 @@ package controller
 import (
 	"fmt"
 
-	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
 	"k8s.io/apimachinery/pkg/api/equality"
 	channelsv1 "open-cluster-management.io/multicloud-operators-channel/pkg/apis/apps/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
-func addChannelController(mgr ctrl.Manager, databaseConnectionPool *pgxpool.Pool) error {
+const (
+	// the channels and the subscriptions of ACM itself are not synced, unless configured otherwise
+	openClusterManagementNamespace = "open-cluster-management"
+)
+
+func addChannelController(mgr ctrl.Manager, specStore specstore.SpecStore, config *Config,
+	outageDetector *databaseOutageDetector) error {
+	reconciler := &genericSpecToDBReconciler{
+		client:             mgr.GetClient(),
+		eventRecorder:      mgr.GetEventRecorderFor(eventRecorderName),
+		specStore:          specStore,
+		log:                ctrl.Log.WithName("channels-spec-syncer"),
+		typeName:           "channels",
+		table:              config.specTable("channels"),
+		finalizerName:      hohCleanupFinalizer,
+		createInstance:     func() client.Object { return &channelsv1.Channel{} },
+		createInstanceList: func() client.ObjectList { return &channelsv1.ChannelList{} },
+		cleanStatus:        cleanChannelStatus,
+		areEqual:           areChannelsEqual,
+		labelSelector:      config.labelSelector("channels"),
+		namespaceFilters: config.namespaceFilters("channels",
+			&NamespaceFilter{Exclude: []string{openClusterManagementNamespace}}),
+		resyncEvents: make(chan event.GenericEvent),
+	}
+
 	if err := ctrl.NewControllerManagedBy(mgr).
 		For(&channelsv1.Channel{}).
-		WithEventFilter(predicate.NewPredicateFuncs(func(object client.Object) bool {
-			return object.GetNamespace() != "open-cluster-management"
-		})).
-		Complete(&genericSpecToDBReconciler{
-			client:                 mgr.GetClient(),
-			databaseConnectionPool: databaseConnectionPool,
-			log:                    ctrl.Log.WithName("channels-spec-syncer"),
-			tableName:              "channels",
-			finalizerName:          hohCleanupFinalizer,
-			createInstance:         func() client.Object { return &channelsv1.Channel{} },
-			cleanStatus:            cleanChannelStatus,
-			areEqual:               areChannelsEqual,
-		}); err != nil {
+		WithEventFilter(reconciler.namespacePredicate()).
+		Watches(&source.Channel{Source: reconciler.resyncEvents}, &handler.EnqueueRequestForObject{}).
+		Complete(reconciler); err != nil {
 		return fmt.Errorf("failed to add channel controller to the manager: %w", err)
 	}
 
+	if err := addDatabaseMaintainers(mgr, reconciler, config, outageDetector); err != nil {
+		return fmt.Errorf("failed to add channel database maintainers to the manager: %w", err)
+	}
+
 	return nil
 }
 
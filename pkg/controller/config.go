@@ -0,0 +1,192 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"time"
+
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/database"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// Config holds the configuration of the controllers. the types to sync are read from a YAML configuration file, the
+// tombstones collection is configured by flags only.
+type Config struct {
+	// EnabledTypes lists the names of the types to sync, i.e. the default names of their tables. empty enables all the
+	// types.
+	EnabledTypes []string `json:"enabledTypes,omitempty"`
+	// LabelSelectors maps the names of types to label selectors, e.g. `environment in (production, staging)`. only the
+	// instances of the type that match its selector are synced, the others are treated as deleted.
+	LabelSelectors map[string]string `json:"labelSelectors,omitempty"`
+	// Namespaces filters the instances of all the types by their namespaces.
+	Namespaces NamespaceFilter `json:"namespaces,omitempty"`
+	// NamespacesPerType maps the names of types to filters of the instances of the type by their namespaces, in
+	// addition to Namespaces. a filter of a type replaces its default filter, e.g. the exclusion of the ACM namespace
+	// from the channels and the subscriptions.
+	NamespacesPerType map[string]NamespaceFilter `json:"namespacesPerType,omitempty"`
+	// UnstructuredTypes lists additional types to sync as unstructured objects, without hand-written controllers.
+	UnstructuredTypes []UnstructuredType `json:"unstructuredTypes,omitempty"`
+	// Schema is the database schema of the tables, spec by default.
+	Schema string `json:"schema,omitempty"`
+	// TableNames maps the names of types to the names of their tables, by default a table is named after its type.
+	TableNames map[string]string `json:"tableNames,omitempty"`
+	// TombstoneRetention is how long rows marked as deleted are kept before they are hard-deleted, zero keeps them
+	// forever. TombstoneRetentionPerType overrides it per type name.
+	TombstoneRetention        time.Duration            `json:"-"`
+	TombstoneRetentionPerType map[string]time.Duration `json:"-"`
+	// TombstoneCollectionPeriod is the period of the hard-deletion of the rows marked as deleted.
+	TombstoneCollectionPeriod time.Duration `json:"-"`
+	// TombstoneAcknowledgementRequired keeps a row marked as deleted, after the retention, until all the consumers of
+	// its table, e.g. the leaf hubs, have acknowledged its version in the acknowledgements table of the table.
+	TombstoneAcknowledgementRequired bool `json:"-"`
+}
+
+// UnstructuredType describes a type to sync as unstructured objects.
+type UnstructuredType struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+	// TableName is the name of the type, e.g. in EnabledTypes, and the default name of its table.
+	TableName string `json:"tableName"`
+	// FieldsToStrip lists the paths of the fields that are removed from the instances before they are synced, in
+	// addition to status, e.g. [spec, remediationAction].
+	FieldsToStrip [][]string `json:"fieldsToStrip,omitempty"`
+	// Namespaces filters the instances of the type by their namespaces, unless NamespacesPerType has a filter of it.
+	Namespaces NamespaceFilter `json:"namespaces,omitempty"`
+}
+
+func (unstructuredType *UnstructuredType) groupVersionKind() schema.GroupVersionKind {
+	return schema.GroupVersionKind{
+		Group:   unstructuredType.Group,
+		Version: unstructuredType.Version,
+		Kind:    unstructuredType.Kind,
+	}
+}
+
+// NamespaceFilter filters instances by their namespaces, with glob patterns, e.g. openshift-*. empty Include includes
+// all the namespaces, Exclude takes precedence over Include. cluster scoped instances are never filtered.
+type NamespaceFilter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+func (filter *NamespaceFilter) matches(namespace string) bool {
+	if namespace == "" {
+		return true
+	}
+
+	if matchesAnyPattern(namespace, filter.Exclude) {
+		return false
+	}
+
+	return len(filter.Include) == 0 || matchesAnyPattern(namespace, filter.Include)
+}
+
+func (filter *NamespaceFilter) validate() error {
+	for _, pattern := range append(append([]string{}, filter.Include...), filter.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid namespace pattern %s: %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+func matchesAnyPattern(namespace string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, namespace); matched { // the patterns are validated on startup
+			return true
+		}
+	}
+
+	return false
+}
+
+// LoadConfigFile reads the configuration from a YAML file into the given configuration.
+func LoadConfigFile(path string, config *Config) error {
+	configFile, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the configuration file: %w", err)
+	}
+
+	if err := yaml.UnmarshalStrict(configFile, config); err != nil {
+		return fmt.Errorf("failed to parse the configuration file: %w", err)
+	}
+
+	return nil
+}
+
+// labelSelector returns the label selector of the type, nil if it has none. the selectors are validated on startup.
+func (config *Config) labelSelector(typeName string) labels.Selector {
+	labelSelector, found := config.LabelSelectors[typeName]
+	if !found {
+		return nil
+	}
+
+	selector, err := labels.Parse(labelSelector)
+	if err != nil {
+		return nil
+	}
+
+	return selector
+}
+
+// namespaceFilters returns the namespace filters of the type: the global filter, and either the filter of the type in
+// the configuration or the given default filter of the type, if any.
+func (config *Config) namespaceFilters(typeName string, defaultFilter *NamespaceFilter) []*NamespaceFilter {
+	namespaceFilters := []*NamespaceFilter{&config.Namespaces}
+
+	if namespaceFilter, found := config.NamespacesPerType[typeName]; found {
+		namespaceFilters = append(namespaceFilters, &namespaceFilter)
+	} else if defaultFilter != nil {
+		namespaceFilters = append(namespaceFilters, defaultFilter)
+	}
+
+	return namespaceFilters
+}
+
+func (config *Config) isTypeEnabled(typeName string) bool {
+	if len(config.EnabledTypes) == 0 {
+		return true
+	}
+
+	for _, enabledType := range config.EnabledTypes {
+		if enabledType == typeName {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (config *Config) databaseSchema() string {
+	if config.Schema == "" {
+		return database.DefaultSchema
+	}
+
+	return config.Schema
+}
+
+// specTable returns the table of the type.
+func (config *Config) specTable(typeName string) database.SpecTable {
+	tableName, found := config.TableNames[typeName]
+	if !found {
+		tableName = typeName
+	}
+
+	return database.SpecTable{Schema: config.databaseSchema(), Name: tableName}
+}
+
+func (config *Config) tombstoneRetention(typeName string) time.Duration {
+	if retention, found := config.TombstoneRetentionPerType[typeName]; found {
+		return retention
+	}
+
+	return config.TombstoneRetention
+}
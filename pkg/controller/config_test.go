@@ -0,0 +1,126 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"errors"
+	"path"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	channelsv1 "open-cluster-management.io/multicloud-operators-channel/pkg/apis/apps/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestTombstoneRetentionIsKeyedByType(t *testing.T) {
+	const retention = 24 * time.Hour
+
+	config := &Config{
+		TableNames:                map[string]string{"policies": "hub_policies"},
+		TombstoneRetentionPerType: map[string]time.Duration{"policies": retention},
+	}
+
+	if err := ValidateConfig(config); err != nil {
+		t.Fatalf("expected a valid configuration, got %v", err)
+	}
+
+	if typeRetention := config.tombstoneRetention("policies"); typeRetention != retention {
+		t.Errorf("expected the retention %s of the type, got %s", retention, typeRetention)
+	}
+
+	config.TombstoneRetentionPerType = map[string]time.Duration{"hub_policies": retention}
+
+	if err := ValidateConfig(config); !errors.Is(err, errUnknownType) {
+		t.Errorf("expected a table name to be rejected as an unknown type, got %v", err)
+	}
+}
+
+func TestNamespaceFilterMatches(t *testing.T) {
+	filter := &NamespaceFilter{Include: []string{"team-*", "default"}, Exclude: []string{"team-b*"}}
+
+	for namespace, expectedMatch := range map[string]bool{
+		"default":   true,
+		"team-a":    true,
+		"team-beta": false, // the exclusion takes precedence
+		"other":     false,
+		"":          true, // cluster scoped instances are never filtered
+	} {
+		if match := filter.matches(namespace); match != expectedMatch {
+			t.Errorf("expected the match of %q to be %t, got %t", namespace, expectedMatch, match)
+		}
+	}
+
+	if !(&NamespaceFilter{}).matches("other") {
+		t.Error("expected an empty filter to match all the namespaces")
+	}
+}
+
+func TestInvalidNamespacePatternsAreRejected(t *testing.T) {
+	if err := (&NamespaceFilter{Include: []string{"team-*"}, Exclude: []string{"openshift-?"}}).validate(); err != nil {
+		t.Errorf("expected valid patterns, got %v", err)
+	}
+
+	for _, filter := range []*NamespaceFilter{{Include: []string{"team-["}}, {Exclude: []string{"[a-"}}} {
+		if err := filter.validate(); !errors.Is(err, path.ErrBadPattern) {
+			t.Errorf("expected the patterns of %+v to be invalid, got %v", filter, err)
+		}
+	}
+
+	config := &Config{NamespacesPerType: map[string]NamespaceFilter{"channels": {Exclude: []string{"team-["}}}}
+
+	if err := ValidateConfig(config); !errors.Is(err, path.ErrBadPattern) {
+		t.Errorf("expected the invalid pattern of the channels to be rejected, got %v", err)
+	}
+}
+
+func TestNamespaceFilterOfTypeReplacesItsDefaultFilter(t *testing.T) {
+	defaultFilter := &NamespaceFilter{Exclude: []string{openClusterManagementNamespace}}
+	config := &Config{
+		Namespaces:        NamespaceFilter{Exclude: []string{"kube-*"}},
+		NamespacesPerType: map[string]NamespaceFilter{"channels": {Include: []string{"open-cluster-*"}}},
+	}
+
+	reconciler := &genericSpecToDBReconciler{namespaceFilters: config.namespaceFilters("channels", defaultFilter)}
+
+	if !reconciler.matchesNamespaceFilters(openClusterManagementNamespace) {
+		t.Error("expected the filter of the channels to replace their default filter")
+	}
+
+	if reconciler.matchesNamespaceFilters("kube-system") || reconciler.matchesNamespaceFilters("default") {
+		t.Error("expected the global filter and the filter of the channels to apply")
+	}
+
+	// the types without a filter in the configuration keep their default filter
+	reconciler.namespaceFilters = config.namespaceFilters("subscriptions", defaultFilter)
+
+	if reconciler.matchesNamespaceFilters(openClusterManagementNamespace) ||
+		!reconciler.matchesNamespaceFilters("default") {
+		t.Error("expected the default filter of the subscriptions to apply")
+	}
+}
+
+func TestNamespacePredicateLetsTheSyncedInstancesThrough(t *testing.T) {
+	reconciler := &genericSpecToDBReconciler{
+		finalizerName:    hohCleanupFinalizer,
+		namespaceFilters: []*NamespaceFilter{{Exclude: []string{openClusterManagementNamespace}}},
+	}
+	namespacePredicate := reconciler.namespacePredicate()
+
+	channel := &channelsv1.Channel{ObjectMeta: metav1.ObjectMeta{Name: "channel", Namespace: "default"}}
+	if !namespacePredicate.Create(event.CreateEvent{Object: channel}) {
+		t.Error("expected the events of an included instance to pass")
+	}
+
+	channel.Namespace = openClusterManagementNamespace
+	if namespacePredicate.Create(event.CreateEvent{Object: channel}) {
+		t.Error("expected the events of an excluded instance to be filtered out")
+	}
+
+	// e.g. an instance synced before its namespace was excluded
+	channel.Finalizers = []string{hohCleanupFinalizer}
+	if !namespacePredicate.Delete(event.DeleteEvent{Object: channel}) {
+		t.Error("expected the events of an excluded instance with the finalizer to pass")
+	}
+}
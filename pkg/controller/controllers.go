@@ -4,63 +4,333 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 
-	"github.com/jackc/pgx/v4/pgxpool"
 	clusterv1alpha1 "github.com/open-cluster-management/api/cluster/v1alpha1"
 	policiesv1 "github.com/open-cluster-management/governance-policy-propagator/api/v1"
 	placementrulesv1 "github.com/open-cluster-management/multicloud-operators-placementrule/pkg/apis/apps/v1"
 	configv1 "github.com/stolostron/hub-of-hubs-data-types/apis/config/v1"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
 	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
 	channelsv1 "open-cluster-management.io/multicloud-operators-channel/pkg/apis/apps/v1"
 	subscriptionsv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
 	applicationv1beta1 "sigs.k8s.io/application/api/v1beta1"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/scheme"
 )
 
-// AddToScheme adds all the resources to be processed to the Scheme.
-func AddToScheme(scheme *runtime.Scheme) error {
-	for _, schemeBuilder := range getSchemeBuilders() {
-		if err := schemeBuilder.AddToScheme(scheme); err != nil {
-			return fmt.Errorf("failed to add scheme: %w", err)
+var (
+	errUnknownType             = errors.New("unknown type")
+	errDuplicateType           = errors.New("duplicate type")
+	errInvalidUnstructuredType = errors.New("invalid unstructured type, version, kind and table name are required")
+	errInvalidTableName        = errors.New("invalid table name, it must not be empty")
+	errDuplicateTableName      = errors.New("duplicate table name")
+)
+
+// syncedType describes a type that the syncer can sync, its name is the name of its table.
+type syncedType struct {
+	name          string
+	logName       string
+	gvk           schema.GroupVersionKind
+	addToScheme   func(*runtime.Scheme) error
+	addController func(ctrl.Manager, specstore.SpecStore, *Config, *databaseOutageDetector) error
+}
+
+func getSyncedTypes(config *Config) []syncedType {
+	syncedTypes := []syncedType{
+		{
+			name: "policies", logName: "policies-spec-syncer",
+			gvk:         policiesv1.GroupVersion.WithKind("Policy"),
+			addToScheme: policiesv1.SchemeBuilder.AddToScheme, addController: addPolicyController,
+		},
+		{
+			name: "placementrules", logName: "placementrules-spec-syncer",
+			gvk:         placementrulesv1.SchemeGroupVersion.WithKind("PlacementRule"),
+			addToScheme: placementrulesv1.SchemeBuilder.AddToScheme, addController: addPlacementRuleController,
+		},
+		{
+			name: "placementbindings", logName: "placementbindings-spec-syncer",
+			gvk:         policiesv1.GroupVersion.WithKind("PlacementBinding"),
+			addToScheme: policiesv1.SchemeBuilder.AddToScheme, addController: addPlacementBindingController,
+		},
+		{
+			name: "configs", logName: "hoh-configs-spec-syncer",
+			gvk:         configv1.GroupVersion.WithKind("Config"),
+			addToScheme: configv1.SchemeBuilder.AddToScheme, addController: addHubOfHubsConfigController,
+		},
+		{
+			name: "applications", logName: "applications-spec-syncer",
+			gvk:         applicationv1beta1.GroupVersion.WithKind("Application"),
+			addToScheme: applicationv1beta1.SchemeBuilder.AddToScheme, addController: addApplicationController,
+		},
+		{
+			name: "subscriptions", logName: "subscriptions-spec-syncer",
+			gvk:         subscriptionsv1.SchemeGroupVersion.WithKind("Subscription"),
+			addToScheme: subscriptionsv1.SchemeBuilder.AddToScheme, addController: addSubscriptionController,
+		},
+		{
+			name: "channels", logName: "channels-spec-syncer",
+			gvk:         channelsv1.SchemeGroupVersion.WithKind("Channel"),
+			addToScheme: channelsv1.SchemeBuilder.AddToScheme, addController: addChannelController,
+		},
+		{
+			name: "managedclustersets", logName: "managedclustersets-spec-syncer",
+			gvk:         clusterv1beta1.GroupVersion.WithKind("ManagedClusterSet"),
+			addToScheme: clusterv1beta1.Install, addController: addManagedClusterSetController,
+		},
+		{
+			name: "managedclustersetbindings", logName: "managedclustersetbindings-spec-syncer",
+			gvk:         clusterv1beta1.GroupVersion.WithKind("ManagedClusterSetBinding"),
+			addToScheme: clusterv1beta1.Install, addController: addManagedClusterSetBindingController,
+		},
+		{
+			name: "placements", logName: "placements-spec-syncer",
+			gvk:         clusterv1alpha1.GroupVersion.WithKind("Placement"),
+			addToScheme: clusterv1alpha1.Install, addController: addPlacementController,
+		},
+	}
+
+	for i := range config.UnstructuredTypes {
+		unstructuredType := config.UnstructuredTypes[i]
+
+		syncedTypes = append(syncedTypes, syncedType{
+			name: unstructuredType.TableName, logName: fmt.Sprintf("%s-spec-syncer", unstructuredType.TableName),
+			gvk:         unstructuredType.groupVersionKind(),
+			addToScheme: func(*runtime.Scheme) error { return nil }, // unstructured objects require no scheme
+			addController: func(mgr ctrl.Manager, specStore specstore.SpecStore, config *Config,
+				outageDetector *databaseOutageDetector) error {
+				return addUnstructuredController(mgr, specStore, config, outageDetector, &unstructuredType)
+			},
+		})
+	}
+
+	return syncedTypes
+}
+
+// ValidateConfig checks that the unstructured types of the configuration are valid and do not duplicate other types,
+// that all the types referenced by the configuration are known, and that the label selectors and namespace patterns
+// are valid.
+func ValidateConfig(config *Config) error {
+	for _, unstructuredType := range config.UnstructuredTypes {
+		if unstructuredType.Version == "" || unstructuredType.Kind == "" || unstructuredType.TableName == "" {
+			return fmt.Errorf("%w: %+v", errInvalidUnstructuredType, unstructuredType)
+		}
+	}
+
+	knownTypes := make(map[string]struct{})
+
+	for _, syncedType := range getSyncedTypes(config) {
+		if _, found := knownTypes[syncedType.name]; found {
+			return fmt.Errorf("%w: %s", errDuplicateType, syncedType.name)
+		}
+
+		knownTypes[syncedType.name] = struct{}{}
+	}
+
+	for _, enabledType := range config.EnabledTypes {
+		if _, found := knownTypes[enabledType]; !found {
+			return fmt.Errorf("%w: %s", errUnknownType, enabledType)
+		}
+	}
+
+	if err := validateNamespaceFilters(config, knownTypes); err != nil {
+		return err
+	}
+
+	if err := validateTableNames(config, knownTypes); err != nil {
+		return err
+	}
+
+	for typeName, labelSelector := range config.LabelSelectors {
+		if _, found := knownTypes[typeName]; !found {
+			return fmt.Errorf("%w: %s", errUnknownType, typeName)
+		}
+
+		if _, err := labels.Parse(labelSelector); err != nil {
+			return fmt.Errorf("invalid label selector of %s: %w", typeName, err)
+		}
+	}
+
+	for typeName := range config.TombstoneRetentionPerType {
+		if _, found := knownTypes[typeName]; !found {
+			return fmt.Errorf("%w: %s", errUnknownType, typeName)
+		}
+	}
+
+	return nil
+}
+
+func validateNamespaceFilters(config *Config, knownTypes map[string]struct{}) error {
+	if err := config.Namespaces.validate(); err != nil {
+		return fmt.Errorf("invalid namespaces: %w", err)
+	}
+
+	for typeName, namespaceFilter := range config.NamespacesPerType {
+		if _, found := knownTypes[typeName]; !found {
+			return fmt.Errorf("%w: %s", errUnknownType, typeName)
+		}
+
+		if err := namespaceFilter.validate(); err != nil {
+			return fmt.Errorf("invalid namespaces of %s: %w", typeName, err)
+		}
+	}
+
+	for _, unstructuredType := range config.UnstructuredTypes {
+		if err := unstructuredType.Namespaces.validate(); err != nil {
+			return fmt.Errorf("invalid namespaces of %s: %w", unstructuredType.TableName, err)
+		}
+	}
+
+	return nil
+}
+
+// EnabledTableNames returns the names of the tables of the enabled types.
+func EnabledTableNames(config *Config) []string {
+	tableNames := make([]string, 0)
+
+	for _, syncedType := range getSyncedTypes(config) {
+		if config.isTypeEnabled(syncedType.name) {
+			tableNames = append(tableNames, config.specTable(syncedType.name).Name)
+		}
+	}
+
+	return tableNames
+}
+
+func validateTableNames(config *Config, knownTypes map[string]struct{}) error {
+	for typeName, tableName := range config.TableNames {
+		if _, found := knownTypes[typeName]; !found {
+			return fmt.Errorf("%w: %s", errUnknownType, typeName)
+		}
+
+		if tableName == "" {
+			return fmt.Errorf("%w: %s", errInvalidTableName, typeName)
 		}
 	}
 
-	// install cluster v1alpha1 / v1beta1 schemes
-	if err := clusterv1beta1.Install(scheme); err != nil {
-		return fmt.Errorf("failed to install scheme: %w", err)
+	tableNames := make(map[string]struct{})
+
+	for typeName := range knownTypes {
+		tableName := config.specTable(typeName).Name
+
+		if _, found := tableNames[tableName]; found {
+			return fmt.Errorf("%w: %s", errDuplicateTableName, tableName)
+		}
+
+		tableNames[tableName] = struct{}{}
 	}
 
-	if err := clusterv1alpha1.Install(scheme); err != nil {
-		return fmt.Errorf("failed to install scheme: %w", err)
+	return nil
+}
+
+// DatabaseSchema returns the database schema of the tables.
+func DatabaseSchema(config *Config) string {
+	return config.databaseSchema()
+}
+
+// AddToScheme adds the resources of the enabled types to the Scheme.
+func AddToScheme(scheme *runtime.Scheme, config *Config) error {
+	for _, syncedType := range getSyncedTypes(config) {
+		if !config.isTypeEnabled(syncedType.name) {
+			continue
+		}
+
+		if err := syncedType.addToScheme(scheme); err != nil {
+			return fmt.Errorf("failed to add scheme of %s: %w", syncedType.name, err)
+		}
 	}
 
 	return nil
 }
 
-func getSchemeBuilders() []*scheme.Builder {
-	return []*scheme.Builder{
-		policiesv1.SchemeBuilder, placementrulesv1.SchemeBuilder, configv1.SchemeBuilder,
-		applicationv1beta1.SchemeBuilder, channelsv1.SchemeBuilder, subscriptionsv1.SchemeBuilder,
+// AddControllers adds the controllers of the enabled types to the Manager. the controllers of the enabled types whose
+// CRDs are not installed, or could not be discovered, are added once their CRDs are discovered.
+func AddControllers(mgr ctrl.Manager, specStore specstore.SpecStore, config *Config) error {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(mgr.GetConfig())
+	if err != nil {
+		return fmt.Errorf("failed to create discovery client: %w", err)
 	}
+
+	return addControllers(mgr, specStore, config, discoveryClient)
 }
 
-// AddControllers adds all the controllers to the Manager.
-func AddControllers(mgr ctrl.Manager, dbConnectionPool *pgxpool.Pool) error {
-	addControllerFunctions := []func(ctrl.Manager, *pgxpool.Pool) error{
-		addPolicyController, addPlacementRuleController,
-		addPlacementBindingController, addHubOfHubsConfigController, addApplicationController,
-		addSubscriptionController, addChannelController,
-		addManagedClusterSetController, addManagedClusterSetBindingController, addPlacementController,
+func addControllers(mgr ctrl.Manager, specStore specstore.SpecStore, config *Config,
+	discoveryClient discovery.DiscoveryInterface) error {
+	// a single detector pings the database for all the types, and requeues the instances of all of them
+	outageDetector := newDatabaseOutageDetector(mgr, specStore)
+	if err := mgr.Add(outageDetector); err != nil {
+		return fmt.Errorf("failed to add database outage detector: %w", err)
 	}
 
-	for _, addControllerFunction := range addControllerFunctions {
-		if err := addControllerFunction(mgr, dbConnectionPool); err != nil {
+	missingTypes := make([]syncedType, 0)
+
+	for _, syncedType := range getSyncedTypes(config) {
+		log := ctrl.Log.WithName(syncedType.logName)
+
+		if !config.isTypeEnabled(syncedType.name) {
+			log.Info("Disabled")
+			continue
+		}
+
+		installed, err := isTypeInstalled(discoveryClient, syncedType.gvk)
+		if err != nil {
+			// e.g. a transient error of the API server, the watcher discovers the type again
+			log.Error(err, "Failed to discover the CRD, retrying in the background", "kind", syncedType.gvk)
+		}
+
+		if !installed {
+			log.Info("Enabled, waiting for the CRD to be installed", "kind", syncedType.gvk)
+			activeTypes.WithLabelValues(syncedType.name).Set(0)
+
+			missingTypes = append(missingTypes, syncedType)
+
+			continue
+		}
+
+		if err := syncedType.addController(mgr, specStore, config, outageDetector); err != nil {
 			return fmt.Errorf("failed to add controller: %w", err)
 		}
+
+		log.Info("Enabled")
+		activeTypes.WithLabelValues(syncedType.name).Set(1)
 	}
 
+	if len(missingTypes) == 0 {
+		return nil
+	}
+
+	if err := mgr.Add(&missingTypesWatcher{
+		mgr:             mgr,
+		specStore:       specStore,
+		config:          config,
+		outageDetector:  outageDetector,
+		discoveryClient: discoveryClient,
+		missingTypes:    missingTypes,
+		period:          missingTypesPollPeriod,
+	}); err != nil {
+		return fmt.Errorf("failed to add missing types watcher: %w", err)
+	}
+
+	return nil
+}
+
+// addDatabaseMaintainers adds the background maintainers of the table of the reconciler to the Manager, and the
+// reconciler to the database outage detector.
+func addDatabaseMaintainers(mgr ctrl.Manager, reconciler *genericSpecToDBReconciler, config *Config,
+	outageDetector *databaseOutageDetector) error {
+	if err := addOrphanedRowsSweeper(mgr, reconciler); err != nil {
+		return fmt.Errorf("failed to add database maintainer: %w", err)
+	}
+
+	if err := addTombstonesCollector(mgr, reconciler, config); err != nil {
+		return fmt.Errorf("failed to add database maintainer: %w", err)
+	}
+
+	outageDetector.addReconciler(reconciler)
+
 	return nil
 }
@@ -0,0 +1,360 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	clusterv1alpha1 "github.com/open-cluster-management/api/cluster/v1alpha1"
+	policiesv1 "github.com/open-cluster-management/governance-policy-propagator/api/v1"
+	placementrulesv1 "github.com/open-cluster-management/multicloud-operators-placementrule/pkg/apis/apps/v1"
+	configv1 "github.com/stolostron/hub-of-hubs-data-types/apis/config/v1"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/retry"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	channelsv1 "open-cluster-management.io/multicloud-operators-channel/pkg/apis/apps/v1"
+	subscriptionsv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	applicationv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// the integration tests run the controllers against the kube-apiserver and etcd of envtest, whose binaries are found
+// by the KUBEBUILDER_ASSETS environment variable, with the in-memory spec store instead of a database.
+const (
+	envtestAssetsEnvironmentVariable = "KUBEBUILDER_ASSETS"
+	envtestNamespace                 = "envtest"
+	envtestUpdatedLabel              = "hub-of-hubs.open-cluster-management.io/envtest-updated"
+	envtestPollInterval              = 100 * time.Millisecond
+	envtestPollTimeout               = 30 * time.Second
+)
+
+// envtestType is a synced type of the integration tests with a valid instance, created in the given namespace unless
+// the type is cluster scoped.
+type envtestType struct {
+	name          string
+	namespace     string
+	clusterScoped bool
+	newInstance   func(meta metav1.ObjectMeta) client.Object
+}
+
+func getEnvtestTypes() []envtestType {
+	return []envtestType{
+		{name: "policies", namespace: envtestNamespace, newInstance: func(meta metav1.ObjectMeta) client.Object {
+			return &policiesv1.Policy{ObjectMeta: meta, Spec: policiesv1.PolicySpec{Disabled: true}}
+		}},
+		{name: "placementrules", namespace: envtestNamespace, newInstance: func(meta metav1.ObjectMeta) client.Object {
+			return &placementrulesv1.PlacementRule{ObjectMeta: meta}
+		}},
+		{name: "placementbindings", namespace: envtestNamespace, newInstance: func(meta metav1.ObjectMeta) client.Object {
+			return &policiesv1.PlacementBinding{
+				ObjectMeta: meta,
+				PlacementRef: policiesv1.Subject{
+					APIGroup: placementrulesv1.SchemeGroupVersion.Group, Kind: "PlacementRule", Name: "placement",
+				},
+				Subjects: []policiesv1.Subject{
+					{APIGroup: policiesv1.GroupVersion.Group, Kind: "Policy", Name: "policy"},
+				},
+			}
+		}},
+		{name: "configs", namespace: hohSystemNamespace, newInstance: func(meta metav1.ObjectMeta) client.Object {
+			return &configv1.Config{ObjectMeta: meta, Spec: configv1.ConfigSpec{AggregationLevel: configv1.Full}}
+		}},
+		{name: "applications", namespace: envtestNamespace, newInstance: func(meta metav1.ObjectMeta) client.Object {
+			return &applicationv1beta1.Application{ObjectMeta: meta}
+		}},
+		{name: "subscriptions", namespace: envtestNamespace, newInstance: func(meta metav1.ObjectMeta) client.Object {
+			return &subscriptionsv1.Subscription{
+				ObjectMeta: meta,
+				Spec:       subscriptionsv1.SubscriptionSpec{Channel: envtestNamespace + "/channel"},
+			}
+		}},
+		{name: "channels", namespace: envtestNamespace, newInstance: func(meta metav1.ObjectMeta) client.Object {
+			return &channelsv1.Channel{
+				ObjectMeta: meta,
+				Spec:       channelsv1.ChannelSpec{Type: channelsv1.ChannelTypeNamespace, Pathname: envtestNamespace},
+			}
+		}},
+		{name: "managedclustersets", clusterScoped: true, newInstance: func(meta metav1.ObjectMeta) client.Object {
+			return &clusterv1beta1.ManagedClusterSet{ObjectMeta: meta}
+		}},
+		{
+			name: "managedclustersetbindings", namespace: envtestNamespace,
+			newInstance: func(meta metav1.ObjectMeta) client.Object {
+				return &clusterv1beta1.ManagedClusterSetBinding{
+					ObjectMeta: meta,
+					Spec:       clusterv1beta1.ManagedClusterSetBindingSpec{ClusterSet: meta.Name},
+				}
+			},
+		},
+		{name: "placements", namespace: envtestNamespace, newInstance: func(meta metav1.ObjectMeta) client.Object {
+			return &clusterv1alpha1.Placement{ObjectMeta: meta}
+		}},
+	}
+}
+
+func (testType *envtestType) newInstanceIn(namespace, name string) client.Object {
+	if testType.clusterScoped {
+		namespace = ""
+	}
+
+	return testType.newInstance(metav1.ObjectMeta{Namespace: namespace, Name: name})
+}
+
+// startEnvtest starts envtest with the CRDs of testdata/crds, and the manager with the controllers of all the types,
+// until the end of the test. it returns a client that reads from the API server, not from the cache of the manager.
+func startEnvtest(t *testing.T, store specstore.SpecStore, config *Config) client.Client {
+	t.Helper()
+
+	if _, found := os.LookupEnv(envtestAssetsEnvironmentVariable); !found {
+		t.Skipf("%s is not set to the directory of the envtest binaries", envtestAssetsEnvironmentVariable)
+	}
+
+	testEnvironment := &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("testdata", "crds")},
+		ErrorIfCRDPathMissing: true,
+	}
+
+	restConfig, err := testEnvironment.Start()
+	if err != nil {
+		t.Fatalf("failed to start envtest: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := testEnvironment.Stop(); err != nil {
+			t.Errorf("failed to stop envtest: %v", err)
+		}
+	})
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to add the client-go scheme: %v", err)
+	}
+
+	if err := AddToScheme(scheme, config); err != nil {
+		t.Fatalf("failed to add the schemes of the types: %v", err)
+	}
+
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{Scheme: scheme, MetricsBindAddress: "0"})
+	if err != nil {
+		t.Fatalf("failed to create the manager: %v", err)
+	}
+
+	if err := AddControllers(mgr, store, config); err != nil {
+		t.Fatalf("failed to add the controllers: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	managerDone := make(chan struct{})
+
+	go func() {
+		defer close(managerDone)
+
+		if err := mgr.Start(ctx); err != nil {
+			t.Errorf("the manager failed: %v", err)
+		}
+	}()
+
+	// the manager is stopped before envtest
+	t.Cleanup(func() {
+		cancel()
+		<-managerDone
+	})
+
+	apiServerClient, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		t.Fatalf("failed to create the client: %v", err)
+	}
+
+	for _, namespace := range []string{envtestNamespace, hohSystemNamespace, openClusterManagementNamespace} {
+		if err := apiServerClient.Create(context.Background(),
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}); err != nil {
+			t.Fatalf("failed to create the namespace %s: %v", namespace, err)
+		}
+	}
+
+	return apiServerClient
+}
+
+// waitForInstance waits until the instance on the API server meets the condition, the condition gets nil once the
+// instance is deleted.
+func waitForInstance(t *testing.T, apiServerClient client.Client, instance client.Object,
+	condition func(instance client.Object) bool) {
+	t.Helper()
+
+	key := client.ObjectKeyFromObject(instance)
+
+	if err := wait.PollImmediate(envtestPollInterval, envtestPollTimeout, func() (bool, error) {
+		err := apiServerClient.Get(context.Background(), key, instance)
+		if apierrors.IsNotFound(err) {
+			return condition(nil), nil
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		return condition(instance), nil
+	}); err != nil {
+		t.Fatalf("the instance %s did not reach the expected state: %v", key, err)
+	}
+}
+
+// waitForRow waits until the row of the instance in the spec store has the revision and the deleted flag.
+func waitForRow(t *testing.T, store specstore.SpecStore, config *Config, typeName string, instance client.Object,
+	revision int64, deleted bool) {
+	t.Helper()
+
+	var row *specstore.Row
+
+	if err := wait.PollImmediate(envtestPollInterval, envtestPollTimeout, func() (bool, error) {
+		var err error
+
+		row, err = store.Get(context.Background(), config.specTable(typeName), string(instance.GetUID()))
+		if errors.Is(err, specstore.ErrRowNotFound) {
+			return false, nil
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		return row.Revision == revision && row.Deleted == deleted, nil
+	}); err != nil {
+		t.Fatalf("expected the row of %s with revision %d and deleted %t, got %+v: %v",
+			client.ObjectKeyFromObject(instance), revision, deleted, row, err)
+	}
+}
+
+func isSyncedWithRevision(instance client.Object, revision int64) bool {
+	return instance != nil && controllerutil.ContainsFinalizer(instance, hohCleanupFinalizer) &&
+		instance.GetAnnotations()[syncStatusAnnotation] == syncStatusSynced &&
+		instance.GetAnnotations()[databaseRevisionAnnotation] == strconv.FormatInt(revision, 10)
+}
+
+func TestControllersWithEnvtest(t *testing.T) {
+	store := specstore.NewInMemorySpecStore()
+	config := &Config{}
+	apiServerClient := startEnvtest(t, store, config)
+
+	for _, testType := range getEnvtestTypes() {
+		testType := testType
+
+		t.Run(testType.name, func(t *testing.T) {
+			testInstanceLifecycle(t, apiServerClient, store, config, &testType)
+		})
+	}
+
+	// the namespace filters of the types exclude instances, unless configured otherwise
+	excludedNamespaces := map[string]string{
+		"channels":      openClusterManagementNamespace,
+		"subscriptions": openClusterManagementNamespace,
+		"configs":       envtestNamespace,
+	}
+
+	for _, testType := range getEnvtestTypes() {
+		testType := testType
+
+		excludedNamespace, found := excludedNamespaces[testType.name]
+		if !found {
+			continue
+		}
+
+		t.Run(testType.name+" namespace filter", func(t *testing.T) {
+			testExcludedNamespace(t, apiServerClient, store, config, &testType, excludedNamespace)
+		})
+	}
+}
+
+// testInstanceLifecycle checks that the controller of the type adds its finalizer to a created instance and inserts
+// it, updates it, and marks it as deleted before it removes its finalizer.
+func testInstanceLifecycle(t *testing.T, apiServerClient client.Client, store specstore.SpecStore, config *Config,
+	testType *envtestType) {
+	ctx := context.Background()
+	instance := testType.newInstanceIn(testType.namespace, "lifecycle-"+testType.name)
+
+	if err := apiServerClient.Create(ctx, instance); err != nil {
+		t.Fatalf("failed to create the instance: %v", err)
+	}
+
+	waitForInstance(t, apiServerClient, instance, func(instance client.Object) bool {
+		return isSyncedWithRevision(instance, 1)
+	})
+	waitForRow(t, store, config, testType.name, instance, 1, false)
+
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := apiServerClient.Get(ctx, client.ObjectKeyFromObject(instance), instance); err != nil {
+			return err
+		}
+
+		instance.SetLabels(map[string]string{envtestUpdatedLabel: "true"})
+
+		return apiServerClient.Update(ctx, instance)
+	}); err != nil {
+		t.Fatalf("failed to update the instance: %v", err)
+	}
+
+	waitForInstance(t, apiServerClient, instance, func(instance client.Object) bool {
+		return isSyncedWithRevision(instance, 2)
+	})
+	waitForRow(t, store, config, testType.name, instance, 2, false)
+
+	if err := apiServerClient.Delete(ctx, instance); err != nil {
+		t.Fatalf("failed to delete the instance: %v", err)
+	}
+
+	// the instance is gone once the controller removed its finalizer
+	waitForInstance(t, apiServerClient, instance, func(instance client.Object) bool {
+		return instance == nil
+	})
+	waitForRow(t, store, config, testType.name, instance, 3, true)
+}
+
+// testExcludedNamespace checks that an instance in the excluded namespace is neither finalized nor synced. the
+// excluded instance is created before an included one, so it is reconciled first.
+func testExcludedNamespace(t *testing.T, apiServerClient client.Client, store specstore.SpecStore, config *Config,
+	testType *envtestType, excludedNamespace string) {
+	ctx := context.Background()
+	excludedInstance := testType.newInstanceIn(excludedNamespace, "excluded-"+testType.name)
+	includedInstance := testType.newInstanceIn(testType.namespace, "included-"+testType.name)
+
+	for _, instance := range []client.Object{excludedInstance, includedInstance} {
+		if err := apiServerClient.Create(ctx, instance); err != nil {
+			t.Fatalf("failed to create the instance: %v", err)
+		}
+	}
+
+	waitForInstance(t, apiServerClient, includedInstance, func(instance client.Object) bool {
+		return isSyncedWithRevision(instance, 1)
+	})
+
+	if err := apiServerClient.Get(ctx, client.ObjectKeyFromObject(excludedInstance), excludedInstance); err != nil {
+		t.Fatalf("failed to get the excluded instance: %v", err)
+	}
+
+	if controllerutil.ContainsFinalizer(excludedInstance, hohCleanupFinalizer) ||
+		excludedInstance.GetAnnotations()[syncStatusAnnotation] != "" {
+		t.Errorf("expected the excluded instance not to be synced, got the finalizers %v and the annotations %v",
+			excludedInstance.GetFinalizers(), excludedInstance.GetAnnotations())
+	}
+
+	if _, err := store.Get(ctx, config.specTable(testType.name),
+		string(excludedInstance.GetUID())); !errors.Is(err, specstore.ErrRowNotFound) {
+		t.Errorf("expected no row of the excluded instance, got error %v", err)
+	}
+}
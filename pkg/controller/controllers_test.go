@@ -0,0 +1,80 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	policiesv1 "github.com/open-cluster-management/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	channelsv1 "open-cluster-management.io/multicloud-operators-channel/pkg/apis/apps/v1"
+)
+
+func TestOnlyTheSchemesOfTheEnabledTypesAreAdded(t *testing.T) {
+	scheme := runtime.NewScheme()
+
+	if err := AddToScheme(scheme, &Config{EnabledTypes: []string{"policies"}}); err != nil {
+		t.Fatalf("failed to add the schemes: %v", err)
+	}
+
+	if !scheme.Recognizes(policiesv1.GroupVersion.WithKind("Policy")) {
+		t.Error("expected the scheme of the enabled policies to be added")
+	}
+
+	if scheme.Recognizes(channelsv1.SchemeGroupVersion.WithKind("Channel")) {
+		t.Error("expected the scheme of the disabled channels not to be added")
+	}
+}
+
+func TestEnabledTableNames(t *testing.T) {
+	config := &Config{
+		EnabledTypes: []string{"policies", "policysets"},
+		TableNames:   map[string]string{"policies": "hub_policies"},
+		UnstructuredTypes: []UnstructuredType{
+			{Group: "policy.open-cluster-management.io", Version: "v1", Kind: "PolicySet", TableName: "policysets"},
+		},
+	}
+
+	if err := ValidateConfig(config); err != nil {
+		t.Fatalf("expected a valid configuration, got %v", err)
+	}
+
+	if tableNames := EnabledTableNames(config); !reflect.DeepEqual(tableNames,
+		[]string{"hub_policies", "policysets"}) {
+		t.Errorf("expected the tables of the enabled types, got %v", tableNames)
+	}
+
+	// all the types are enabled by default
+	if tableNames := EnabledTableNames(&Config{}); len(tableNames) != len(getSyncedTypes(&Config{})) {
+		t.Errorf("expected the tables of all the types, got %v", tableNames)
+	}
+}
+
+func TestInvalidTypesAreRejected(t *testing.T) {
+	for name, testCase := range map[string]struct {
+		config      *Config
+		expectedErr error
+	}{
+		"unknown enabled type": {
+			config:      &Config{EnabledTypes: []string{"policysets"}},
+			expectedErr: errUnknownType,
+		},
+		"unstructured type without a kind": {
+			config:      &Config{UnstructuredTypes: []UnstructuredType{{Version: "v1", TableName: "policysets"}}},
+			expectedErr: errInvalidUnstructuredType,
+		},
+		"unstructured type with the name of a type": {
+			config: &Config{UnstructuredTypes: []UnstructuredType{
+				{Group: "policy.open-cluster-management.io", Version: "v1", Kind: "Policy", TableName: "policies"},
+			}},
+			expectedErr: errDuplicateType,
+		},
+	} {
+		if err := ValidateConfig(testCase.config); !errors.Is(err, testCase.expectedErr) {
+			t.Errorf("%s: expected %v, got %v", name, testCase.expectedErr, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+const (
+	databaseOutageDetectionPeriod = 10 * time.Second
+	databasePingTimeout           = 5 * time.Second
+)
+
+// databaseOutageDetector pings the database periodically, and requeues all the instances of all the reconcilers once
+// the database is available again after an outage, so that the changes made on hub during the outage are synced
+// without waiting for the backoff of the failed reconciles. the reconcilers are added as their controllers are added,
+// also after the detector has started, e.g. once the CRD of a type is installed.
+type databaseOutageDetector struct {
+	specStore specstore.SpecStore
+	cache     cache.Cache
+	log       logr.Logger
+	period    time.Duration
+	available bool
+
+	reconcilersMutex sync.Mutex
+	reconcilers      []*genericSpecToDBReconciler
+}
+
+func newDatabaseOutageDetector(mgr ctrl.Manager, specStore specstore.SpecStore) *databaseOutageDetector {
+	return &databaseOutageDetector{
+		specStore: specStore,
+		cache:     mgr.GetCache(),
+		log:       ctrl.Log.WithName("database-outage-detector"),
+		period:    databaseOutageDetectionPeriod,
+		available: true, // the syncer starts once connected to the database
+	}
+}
+
+// addReconciler adds a reconciler whose instances are requeued after an outage.
+func (d *databaseOutageDetector) addReconciler(reconciler *genericSpecToDBReconciler) {
+	d.reconcilersMutex.Lock()
+	defer d.reconcilersMutex.Unlock()
+
+	d.reconcilers = append(d.reconcilers, reconciler)
+}
+
+func (d *databaseOutageDetector) getReconcilers() []*genericSpecToDBReconciler {
+	d.reconcilersMutex.Lock()
+	defer d.reconcilersMutex.Unlock()
+
+	return append([]*genericSpecToDBReconciler(nil), d.reconcilers...)
+}
+
+// Start runs the detection until the context is done. the detector requires leader election, like the controllers.
+func (d *databaseOutageDetector) Start(ctx context.Context) error {
+	if !d.cache.WaitForCacheSync(ctx) {
+		return errCacheNotSynced
+	}
+
+	databaseAvailable.Set(1)
+
+	wait.UntilWithContext(ctx, d.detect, d.period)
+
+	return nil
+}
+
+func (d *databaseOutageDetector) detect(ctx context.Context) {
+	pingCtx, cancel := context.WithTimeout(ctx, databasePingTimeout)
+	defer cancel()
+
+	if err := d.specStore.Ping(pingCtx); err != nil {
+		if d.available {
+			d.log.Error(err, "Database outage detected")
+			databaseAvailable.Set(0)
+		}
+
+		d.available = false
+
+		return
+	}
+
+	if d.available {
+		return
+	}
+
+	d.log.Info("The database is available again, requeueing all the instances")
+
+	// the instances of all the reconcilers are requeued again on the next detection if any requeue fails, requeueing
+	// an instance twice is harmless
+	for _, reconciler := range d.getReconcilers() {
+		requeued, err := requeueAllInstances(ctx, reconciler)
+		if err != nil {
+			d.log.Error(err, "Failed to requeue the instances", "table", reconciler.table.Name)
+			return // retried on the next detection
+		}
+
+		d.log.Info("Requeued all the instances", "table", reconciler.table.Name, "instances", requeued)
+	}
+
+	d.available = true
+
+	databaseAvailable.Set(1)
+}
+
+func requeueAllInstances(ctx context.Context, reconciler *genericSpecToDBReconciler) (int, error) {
+	instanceList := reconciler.createInstanceList()
+
+	if err := reconciler.client.List(ctx, instanceList); err != nil {
+		return 0, fmt.Errorf("failed to list the instances: %w", err)
+	}
+
+	instances, err := meta.ExtractList(instanceList)
+	if err != nil {
+		return 0, fmt.Errorf("failed to extract the instances from the list: %w", err)
+	}
+
+	for _, object := range instances {
+		instance, ok := object.(client.Object)
+		if !ok {
+			return 0, fmt.Errorf("%w: %T", errUnexpectedObjectType, object)
+		}
+
+		select {
+		case reconciler.resyncEvents <- event.GenericEvent{Object: instance}:
+		case <-ctx.Done():
+			return 0, fmt.Errorf("failed to requeue the instances: %w", ctx.Err())
+		}
+	}
+
+	return len(instances), nil
+}
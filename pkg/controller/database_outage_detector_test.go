@@ -0,0 +1,65 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// pingFailingSpecStore fails the pings while pingErr is set.
+type pingFailingSpecStore struct {
+	specstore.SpecStore
+	pingErr error
+}
+
+func (s *pingFailingSpecStore) Ping(context.Context) error {
+	return s.pingErr
+}
+
+func TestOutageDetectorRequeuesTheInstancesOfAllTheReconcilers(t *testing.T) {
+	store := &pingFailingSpecStore{SpecStore: specstore.NewInMemorySpecStore()}
+	detector := &databaseOutageDetector{specStore: store, log: logr.Discard(), available: true}
+
+	reconcilers := []*genericSpecToDBReconciler{
+		newTestPolicyReconciler(t, store, newTestPolicy("10", false)),
+		newTestPolicyReconciler(t, store, newTestPolicy("20", false)),
+	}
+
+	for _, reconciler := range reconcilers {
+		reconciler.resyncEvents = make(chan event.GenericEvent, 1)
+		detector.addReconciler(reconciler)
+	}
+
+	store.pingErr = errTestDatabaseUnavailable
+	detector.detect(context.Background())
+
+	if available := testutil.ToFloat64(databaseAvailable); available != 0 {
+		t.Errorf("expected the database to be unavailable, got %v", available)
+	}
+
+	store.pingErr = nil
+	detector.detect(context.Background())
+
+	if available := testutil.ToFloat64(databaseAvailable); available != 1 {
+		t.Errorf("expected the database to be available, got %v", available)
+	}
+
+	for i, reconciler := range reconcilers {
+		select {
+		case resyncEvent := <-reconciler.resyncEvents:
+			if resyncEvent.Object.GetName() != "policy" {
+				t.Errorf("expected the policy of reconciler %d to be requeued, got %s", i,
+					resyncEvent.Object.GetName())
+			}
+		default:
+			t.Errorf("expected the instances of reconciler %d to be requeued", i)
+		}
+	}
+}
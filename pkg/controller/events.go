@@ -0,0 +1,38 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// the reasons of the events emitted on the instances on hub.
+const (
+	eventReasonInserted       = "Inserted"
+	eventReasonUpdated        = "Updated"
+	eventReasonDeleted        = "Deleted"
+	eventReasonDatabaseError  = "DatabaseError"
+	eventReasonFinalizerError = "FinalizerError"
+	eventReasonConflict       = "Conflict"
+)
+
+// recordUpsertEvent emits a Normal event if the row of the instance was inserted or updated, nothing if it was up to
+// date, and a Warning event if the update overwrote a newer hub resource version.
+func (r *genericSpecToDBReconciler) recordUpsertEvent(instance client.Object, result *specstore.UpsertResult) {
+	switch result.Operation {
+	case specstore.OperationInsert:
+		r.eventRecorder.Eventf(instance, corev1.EventTypeNormal, eventReasonInserted,
+			"Inserted into the database table %s, revision %d", r.table, result.Revision)
+	case specstore.OperationUpdate:
+		r.eventRecorder.Eventf(instance, corev1.EventTypeNormal, eventReasonUpdated,
+			"Updated in the database table %s, revision %d", r.table, result.Revision)
+	}
+
+	if result.HubResourceVersionConflict {
+		r.eventRecorder.Eventf(instance, corev1.EventTypeWarning, eventReasonConflict,
+			"Overwrote a newer hub resource version in the database table %s, revision %d", r.table, result.Revision)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	policiesv1 "github.com/open-cluster-management/governance-policy-propagator/api/v1"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var errTestUpdateRejected = errors.New("the update is rejected")
+
+// updateRejectingClient fails all the updates of the instances, e.g. of their finalizers.
+type updateRejectingClient struct {
+	client.Client
+}
+
+func (c *updateRejectingClient) Update(context.Context, client.Object, ...client.UpdateOption) error {
+	return errTestUpdateRejected
+}
+
+// newTestEventsReconciler creates a policies reconciler that records its events in the returned recorder.
+func newTestEventsReconciler(t *testing.T, store specstore.SpecStore,
+	objects ...client.Object) (*genericSpecToDBReconciler, *record.FakeRecorder) {
+	t.Helper()
+
+	reconciler := newTestPolicyReconciler(t, store, objects...)
+	eventRecorder := record.NewFakeRecorder(testEventsBufferSize)
+	reconciler.eventRecorder = eventRecorder
+
+	return reconciler, eventRecorder
+}
+
+func TestUpsertEvents(t *testing.T) {
+	reconciler, eventRecorder := newTestEventsReconciler(t, specstore.NewInMemorySpecStore(),
+		newTestPolicy("10", false))
+
+	reconcileTestPolicy(t, reconciler)
+
+	if !hasTestEvent(eventRecorder, corev1.EventTypeNormal+" "+eventReasonInserted) {
+		t.Errorf("expected a %s event on the first sync", eventReasonInserted)
+	}
+
+	policy := &policiesv1.Policy{}
+	if err := reconciler.client.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "policy"},
+		policy); err != nil {
+		t.Fatalf("failed to get the policy: %v", err)
+	}
+
+	policy.Spec.Disabled = true
+	if err := reconciler.client.Update(context.Background(), policy); err != nil {
+		t.Fatalf("failed to update the policy: %v", err)
+	}
+
+	reconcileTestPolicy(t, reconciler)
+
+	if !hasTestEvent(eventRecorder, corev1.EventTypeNormal+" "+eventReasonUpdated) {
+		t.Errorf("expected an %s event once the policy changed", eventReasonUpdated)
+	}
+
+	reconcileTestPolicy(t, reconciler)
+
+	if len(eventRecorder.Events) != 0 {
+		t.Errorf("expected no event when the row is up to date, got %q", <-eventRecorder.Events)
+	}
+}
+
+func TestDeletedEvent(t *testing.T) {
+	policy := newTestPolicy("10", false)
+	policy.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+
+	reconciler, eventRecorder := newTestEventsReconciler(t, specstore.NewInMemorySpecStore(), policy)
+	upsertTestRow(t, reconciler, testPolicyUID, "policy")
+
+	reconcileTestPolicy(t, reconciler)
+
+	if !hasTestEvent(eventRecorder, corev1.EventTypeNormal+" "+eventReasonDeleted) {
+		t.Errorf("expected a %s event", eventReasonDeleted)
+	}
+}
+
+func TestDatabaseErrorEvents(t *testing.T) {
+	deletedPolicy := newTestPolicy("10", false)
+	deletedPolicy.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+
+	for name, policy := range map[string]client.Object{
+		"upsert":   newTestPolicy("10", false),
+		"deletion": deletedPolicy,
+	} {
+		t.Run(name, func(t *testing.T) {
+			reconciler, eventRecorder := newTestEventsReconciler(t,
+				&unavailableSpecStore{SpecStore: specstore.NewInMemorySpecStore()}, policy)
+
+			if _, err := reconciler.Reconcile(context.Background(), ctrl.Request{
+				NamespacedName: types.NamespacedName{Namespace: "default", Name: "policy"},
+			}); !errors.Is(err, errTestDatabaseUnavailable) {
+				t.Errorf("expected the error of the spec store, got %v", err)
+			}
+
+			if !hasTestEvent(eventRecorder, corev1.EventTypeWarning+" "+eventReasonDatabaseError) {
+				t.Errorf("expected a %s event", eventReasonDatabaseError)
+			}
+		})
+	}
+}
+
+func TestFinalizerErrorEvent(t *testing.T) {
+	policy := newTestPolicy("10", false)
+	policy.Finalizers = nil
+
+	reconciler, eventRecorder := newTestEventsReconciler(t, specstore.NewInMemorySpecStore(), policy)
+	reconciler.client = &updateRejectingClient{Client: reconciler.client}
+
+	if _, err := reconciler.Reconcile(context.Background(), ctrl.Request{
+		NamespacedName: types.NamespacedName{Namespace: "default", Name: "policy"},
+	}); !errors.Is(err, errFinalizerUpdate) {
+		t.Errorf("expected the error of the finalizer update, got %v", err)
+	}
+
+	if !hasTestEvent(eventRecorder, corev1.EventTypeWarning+" "+eventReasonFinalizerError) {
+		t.Errorf("expected a %s event", eventReasonFinalizerError)
+	}
+}
@@ -5,103 +5,214 @@ package controller
 
 import (
 	"context"
-	"errors"
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/go-logr/logr"
-	pgx "github.com/jackc/pgx/v4"
-	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/database"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
 type genericSpecToDBReconciler struct {
-	client                 client.Client
-	log                    logr.Logger
-	databaseConnectionPool *pgxpool.Pool
-	tableName              string
-	finalizerName          string
-	createInstance         func() client.Object
-	cleanStatus            func(client.Object)
-	areEqual               func(client.Object, client.Object) bool
+	client             client.Client
+	eventRecorder      record.EventRecorder
+	log                logr.Logger
+	specStore          specstore.SpecStore
+	typeName           string
+	table              database.SpecTable
+	finalizerName      string
+	createInstance     func() client.Object
+	createInstanceList func() client.ObjectList
+	cleanStatus        func(client.Object)
+	// areEqual is optional, it is only called if the payload hash of the database row does not match the instance
+	areEqual func(client.Object, client.Object) bool
+	// labelSelector is optional, the instances that do not match it are treated as deleted
+	labelSelector labels.Selector
+	// the instances that do not match all the namespace filters are treated as deleted
+	namespaceFilters []*NamespaceFilter
+	// the instances sent to resyncEvents are requeued, e.g. once the database is available again after an outage
+	resyncEvents chan event.GenericEvent
 }
 
 const (
 	requeuePeriodSeconds = 5
 	hohCleanupFinalizer  = "hub-of-hubs.open-cluster-management.io/resource-cleanup"
+	eventRecorderName    = "hub-of-hubs-spec-sync"
+	// instances annotated with skipSyncAnnotation set to "true" are treated as deleted
+	skipSyncAnnotation = "hub-of-hubs.open-cluster-management.io/skip-sync"
 )
 
 func (r *genericSpecToDBReconciler) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.Result, error) {
 	reqLogger := r.log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
-	reqLogger.Info(fmt.Sprintf("Reconciling %s ...", r.tableName))
+	reqLogger.Info(fmt.Sprintf("Reconciling %s ...", r.table.Name))
 
-	instanceUID, instance, err := r.processCR(ctx, request, reqLogger)
+	defer inFlightReconciles.start(r.table.Name)()
+
+	instance, err := r.processCR(ctx, request, reqLogger)
 	if err != nil {
 		reqLogger.Error(err, "Reconciliation failed")
+		syncFailures.WithLabelValues(r.table.Name).Inc()
+
 		return ctrl.Result{Requeue: true, RequeueAfter: requeuePeriodSeconds * time.Second}, err
 	}
 
 	if instance == nil {
 		reqLogger.Info("Reconciliation complete.")
+		lastSuccessfulSyncTime.WithLabelValues(r.typeName).SetToCurrentTime()
+
 		return ctrl.Result{}, nil
 	}
 
-	instanceInTheDatabase, err := r.processInstanceInTheDatabase(ctx, instance, instanceUID, reqLogger)
+	instanceUID := string(instance.GetUID())
+
+	cleanedInstance, ok := instance.DeepCopyObject().(client.Object)
+	if !ok {
+		panic("wrong instance passed to Reconcile: its deep copy is not a client.Object")
+	}
+
+	result, err := r.upsertInstanceInTheDatabase(ctx, r.cleanInstance(cleanedInstance), instanceUID,
+		getHubResourceVersion(instance), reqLogger)
 	if err != nil {
 		reqLogger.Error(err, "Reconciliation failed")
-		return ctrl.Result{Requeue: true, RequeueAfter: requeuePeriodSeconds * time.Second}, err
+		r.eventRecorder.Event(instance, corev1.EventTypeWarning, eventReasonDatabaseError, err.Error())
+
+		syncFailures.WithLabelValues(r.table.Name).Inc()
+
+		return ctrl.Result{Requeue: true, RequeueAfter: requeuePeriodSeconds * time.Second},
+			r.setFailedSyncStatusOnError(ctx, instance, err, reqLogger)
+	}
+
+	if result.Operation != "" {
+		rowsWritten.WithLabelValues(r.table.Name, result.Operation).Inc()
 	}
 
-	if !r.areEqual(instance, instanceInTheDatabase) {
-		reqLogger.Info("Mismatch between hub and the database, updating the database")
+	r.recordUpsertEvent(instance, result)
 
-		_, err := r.databaseConnectionPool.Exec(ctx,
-			fmt.Sprintf("UPDATE spec.%s SET payload = $1 WHERE id = $2", r.tableName),
-			&instance, instanceUID)
-		if err != nil {
-			// wrap the error from an external package, see https://github.com/tomarrell/wrapcheck
-			err = fmt.Errorf("failed to update the database with new value: %w", err)
-			reqLogger.Error(err, "Reconciliation failed")
+	if err := r.setSyncedSyncStatus(ctx, instance, instanceUID, result.Revision, reqLogger); err != nil {
+		reqLogger.Error(err, "Reconciliation failed")
+		syncFailures.WithLabelValues(r.table.Name).Inc()
 
-			return ctrl.Result{}, err
-		}
+		return ctrl.Result{Requeue: true, RequeueAfter: requeuePeriodSeconds * time.Second}, err
 	}
 
 	reqLogger.Info("Reconciliation complete.")
+	lastSuccessfulSyncTime.WithLabelValues(r.typeName).SetToCurrentTime()
 
-	return ctrl.Result{}, err
+	return ctrl.Result{}, nil
 }
 
+// processCR returns the instance to sync, nil if it was deleted or excluded.
 func (r *genericSpecToDBReconciler) processCR(ctx context.Context, request ctrl.Request,
-	log logr.Logger) (string, client.Object, error) {
+	log logr.Logger) (client.Object, error) {
 	instance := r.createInstance()
 
 	err := r.client.Get(ctx, request.NamespacedName, instance)
 	if apierrors.IsNotFound(err) {
-		// the instance on hub was deleted, update all the matching instances in the database as deleted
-		return "", nil, r.deleteFromTheDatabase(ctx, request.Name, request.Namespace, log)
+		// the instance on hub was deleted and its UID is unknown, update all the instances in the database with its
+		// name as deleted, the namespace of cluster scoped instances is empty
+		return nil, r.deleteFromTheDatabase(ctx, specstore.RowFilter{Name: request.Name, Namespace: request.Namespace},
+			0, log)
 	}
 
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to get the instance from hub: %w", err)
+		return nil, fmt.Errorf("failed to get the instance from hub: %w", err)
 	}
 
 	if isInstanceBeingDeleted(instance) {
-		return "", nil, r.removeFinalizerAndDelete(ctx, instance, log)
+		return nil, r.setFailedSyncStatusOnError(ctx, instance, r.removeFinalizerAndDelete(ctx, instance, log), log)
+	}
+
+	if r.isInstanceExcluded(instance) {
+		return nil, r.setFailedSyncStatusOnError(ctx, instance, r.removeFinalizerAndDeleteExcluded(ctx, instance, log),
+			log)
 	}
 
-	err = r.addFinalizer(ctx, instance, log)
+	if err := r.addFinalizer(ctx, instance, log); err != nil {
+		return nil, r.setFailedSyncStatusOnError(ctx, instance, err, log)
+	}
 
-	return string(instance.GetUID()), r.cleanInstance(instance), err
+	return instance, nil
+}
+
+// getHubResourceVersion returns the resource version of the instance, which orders the copies of the instance, or 0 if
+// it is not numeric. the API server does not guarantee that resource versions are numeric, but they are with etcd.
+func getHubResourceVersion(instance client.Object) int64 {
+	const (
+		base    = 10
+		bitSize = 64
+	)
+
+	hubResourceVersion, err := strconv.ParseInt(instance.GetResourceVersion(), base, bitSize)
+	if err != nil {
+		return 0
+	}
+
+	return hubResourceVersion
 }
 
 func isInstanceBeingDeleted(instance client.Object) bool {
 	return !instance.GetDeletionTimestamp().IsZero()
 }
 
+// isInstanceExcluded checks if the instance opted out of the sync by the skip sync annotation, or does not match the
+// namespace filters or the label selector of its type.
+func (r *genericSpecToDBReconciler) isInstanceExcluded(instance client.Object) bool {
+	if instance.GetAnnotations()[skipSyncAnnotation] == "true" {
+		return true
+	}
+
+	if !r.matchesNamespaceFilters(instance.GetNamespace()) {
+		return true
+	}
+
+	return r.labelSelector != nil && !r.labelSelector.Matches(labels.Set(instance.GetLabels()))
+}
+
+func (r *genericSpecToDBReconciler) matchesNamespaceFilters(namespace string) bool {
+	for _, namespaceFilter := range r.namespaceFilters {
+		if !namespaceFilter.matches(namespace) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// namespacePredicate filters out the events of the instances that do not match the namespace filters, so that they
+// never reach the queue. the events of the instances with the finalizer pass, e.g. of instances that were synced
+// before their namespace was excluded, so that their rows are marked as deleted and their finalizer is removed.
+func (r *genericSpecToDBReconciler) namespacePredicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(instance client.Object) bool {
+		return r.matchesNamespaceFilters(instance.GetNamespace()) ||
+			controllerutil.ContainsFinalizer(instance, r.finalizerName)
+	})
+}
+
+// removeFinalizerAndDeleteExcluded treats an excluded instance as deleted, since it may have been synced before it was
+// excluded.
+func (r *genericSpecToDBReconciler) removeFinalizerAndDeleteExcluded(ctx context.Context, instance client.Object,
+	log logr.Logger) error {
+	if !controllerutil.ContainsFinalizer(instance, r.finalizerName) {
+		// the instance has not been synced, or its rows have already been marked as deleted
+		return nil
+	}
+
+	log.Info("Instance is excluded from the sync")
+
+	return r.removeFinalizerAndDelete(ctx, instance, log)
+}
+
 func (r *genericSpecToDBReconciler) removeFinalizerAndDelete(ctx context.Context, instance client.Object,
 	log logr.Logger) error {
 	if !controllerutil.ContainsFinalizer(instance, r.finalizerName) {
@@ -110,16 +221,24 @@ func (r *genericSpecToDBReconciler) removeFinalizerAndDelete(ctx context.Context
 
 	log.Info("Removing an instance from the database")
 
-	// the policy is being deleted, update all the matching policies in the database as deleted
-	if err := r.deleteFromTheDatabase(ctx, instance.GetName(), instance.GetNamespace(), log); err != nil {
+	// only the row of the UID of the instance is marked as deleted, a new instance with the same name may already be
+	// synced
+	if err := r.deleteFromTheDatabase(ctx, specstore.RowFilter{IDs: []string{string(instance.GetUID())}},
+		getHubResourceVersion(instance), log); err != nil {
+		r.eventRecorder.Event(instance, corev1.EventTypeWarning, eventReasonDatabaseError, err.Error())
 		return fmt.Errorf("failed to delete an instance from the database: %w", err)
 	}
 
+	r.eventRecorder.Eventf(instance, corev1.EventTypeNormal, eventReasonDeleted,
+		"Marked as deleted in the database table %s", r.table)
+
 	log.Info("Removing finalizer")
 	controllerutil.RemoveFinalizer(instance, r.finalizerName)
 
 	if err := r.client.Update(ctx, instance); err != nil {
-		return fmt.Errorf("failed to remove a finalizer: %w", err)
+		r.eventRecorder.Eventf(instance, corev1.EventTypeWarning, eventReasonFinalizerError,
+			"Failed to remove finalizer %s: %v", r.finalizerName, err)
+		return fmt.Errorf("%w: failed to remove %s: %v", errFinalizerUpdate, r.finalizerName, err)
 	}
 
 	return nil
@@ -134,39 +253,78 @@ func (r *genericSpecToDBReconciler) addFinalizer(ctx context.Context, instance c
 	controllerutil.AddFinalizer(instance, r.finalizerName)
 
 	if err := r.client.Update(ctx, instance); err != nil {
-		return fmt.Errorf("failed to add a finalizer: %w", err)
+		r.eventRecorder.Eventf(instance, corev1.EventTypeWarning, eventReasonFinalizerError,
+			"Failed to add finalizer %s: %v", r.finalizerName, err)
+		return fmt.Errorf("%w: failed to add %s: %v", errFinalizerUpdate, r.finalizerName, err)
 	}
 
 	return nil
 }
 
-func (r *genericSpecToDBReconciler) processInstanceInTheDatabase(ctx context.Context, instance client.Object,
-	instanceUID string, log logr.Logger) (client.Object, error) {
-	instanceInTheDatabase := r.createInstance()
-	err := r.databaseConnectionPool.QueryRow(ctx,
-		fmt.Sprintf("SELECT payload FROM spec.%s WHERE id = $1", r.tableName),
-		instanceUID).Scan(&instanceInTheDatabase)
+// upsertInstanceInTheDatabase upserts the instance in the spec store, and returns the result of the upsert.
+func (r *genericSpecToDBReconciler) upsertInstanceInTheDatabase(ctx context.Context, instance client.Object,
+	instanceUID string, hubResourceVersion int64, log logr.Logger) (*specstore.UpsertResult, error) {
+	payload, err := json.Marshal(instance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the instance: %w", err)
+	}
 
-	if errors.Is(err, pgx.ErrNoRows) {
-		log.Info("The instance with the current UID does not exist in the database, inserting...")
+	payloadHash, err := computePayloadHash(instance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute the payload hash: %w", err)
+	}
 
-		_, err := r.databaseConnectionPool.Exec(ctx,
-			fmt.Sprintf("INSERT INTO spec.%s (id,payload) values($1, $2::jsonb)", r.tableName),
-			instanceUID, &instance)
-		if err != nil {
-			return nil, fmt.Errorf("insert into database failed: %w", err)
-		}
+	result, err := r.specStore.Upsert(ctx, r.table, &specstore.Row{
+		ID:                 instanceUID,
+		Name:               instance.GetName(),
+		Namespace:          instance.GetNamespace(),
+		Payload:            payload,
+		PayloadHash:        payloadHash,
+		HubResourceVersion: hubResourceVersion,
+	}, r.isEqualToPayload(instance))
+	if err != nil {
+		return nil, fmt.Errorf("failed to upsert the instance in the database: %w", err)
+	}
 
-		log.Info("The instance has been inserted into the database")
+	if result.HubResourceVersionConflict {
+		// e.g. an old leader with a stale cache, the last write wins and the next reconcile writes the current copy
+		log.Info("The database had a copy of the instance with a newer hub resource version, it has been overwritten",
+			"hubResourceVersion", hubResourceVersion)
+	}
 
-		return instance, nil // the instance in the database is identical to the instance we just inserted
+	switch result.Operation {
+	case specstore.OperationInsert:
+		log.Info("The instance with the current UID did not exist in the database, it has been inserted")
+	case specstore.OperationUpdate:
+		log.Info("Mismatch between hub and the database, the database has been updated")
 	}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to get the instance in the database: %w", err)
+	if len(result.MarkedAsDeletedIDs) > 0 {
+		log.Info("Previous instances with the same name have been updated as deleted in the database",
+			"ids", result.MarkedAsDeletedIDs)
+		rowsWritten.WithLabelValues(r.table.Name, specstore.OperationDelete).Add(
+			float64(len(result.MarkedAsDeletedIDs)))
 	}
 
-	return instanceInTheDatabase, nil
+	return result, nil
+}
+
+// isEqualToPayload returns the comparison of the instance with the payload of its row for the spec store, nil if the
+// type has no areEqual override.
+func (r *genericSpecToDBReconciler) isEqualToPayload(instance client.Object) func([]byte) (bool, error) {
+	if r.areEqual == nil {
+		return nil
+	}
+
+	return func(payload []byte) (bool, error) {
+		instanceInTheDatabase := r.createInstance()
+
+		if err := json.Unmarshal(payload, instanceInTheDatabase); err != nil {
+			return false, fmt.Errorf("failed to unmarshal the instance in the database: %w", err)
+		}
+
+		return r.areEqual(instance, instanceInTheDatabase), nil
+	}
 }
 
 func (r *genericSpecToDBReconciler) cleanInstance(instance client.Object) client.Object {
@@ -178,30 +336,29 @@ func (r *genericSpecToDBReconciler) cleanInstance(instance client.Object) client
 	instance.SetOwnerReferences(nil)
 	instance.SetClusterName("")
 
-	delete(instance.GetAnnotations(), "kubectl.kubernetes.io/last-applied-configuration")
+	// the annotations are set back since unstructured objects return a copy of their annotations
+	if annotations := instance.GetAnnotations(); annotations != nil {
+		delete(annotations, "kubectl.kubernetes.io/last-applied-configuration")
+
+		for _, syncStatusAnnotation := range getSyncStatusAnnotations() {
+			delete(annotations, syncStatusAnnotation)
+		}
+
+		instance.SetAnnotations(annotations)
+	}
 
 	r.cleanStatus(instance)
 
 	return instance
 }
 
-func (r *genericSpecToDBReconciler) deleteFromTheDatabase(ctx context.Context, name, namespace string,
-	log logr.Logger) error {
+// deleteFromTheDatabase marks the rows of the instance, selected by the filter, as deleted. the hub resource version
+// of the deleted instance is 0 if unknown.
+func (r *genericSpecToDBReconciler) deleteFromTheDatabase(ctx context.Context, filter specstore.RowFilter,
+	hubResourceVersion int64, log logr.Logger) error {
 	log.Info("Instance was deleted, update the deleted field in the database")
 
-	var err error
-
-	if namespace != "" {
-		_, err = r.databaseConnectionPool.Exec(ctx,
-			fmt.Sprintf(`UPDATE spec.%s SET deleted = true WHERE payload -> 'metadata' ->> 'name' = $1 AND
-			     payload -> 'metadata' ->> 'namespace' = $2 AND deleted = false`, r.tableName), name, namespace)
-	} else {
-		_, err = r.databaseConnectionPool.Exec(ctx,
-			fmt.Sprintf(`UPDATE spec.%s SET deleted = true WHERE payload -> 'metadata' ->> 'name' = $1 AND
-			     payload -> 'metadata' ->> 'namespace' IS NULL AND deleted = false`, r.tableName), name)
-	}
-
-	if err != nil {
+	if _, err := r.markRowsAsDeleted(ctx, filter, hubResourceVersion); err != nil {
 		return fmt.Errorf("failed to delete instance from the database: %w", err)
 	}
 
@@ -209,3 +366,17 @@ func (r *genericSpecToDBReconciler) deleteFromTheDatabase(ctx context.Context, n
 
 	return nil
 }
+
+// markRowsAsDeleted marks the not deleted rows that match the filter as deleted, and returns the number of rows marked
+// as deleted.
+func (r *genericSpecToDBReconciler) markRowsAsDeleted(ctx context.Context, filter specstore.RowFilter,
+	hubResourceVersion int64) (int, error) {
+	markedRowUIDs, err := r.specStore.MarkDeleted(ctx, r.table, filter, hubResourceVersion)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark rows as deleted: %w", err)
+	}
+
+	rowsWritten.WithLabelValues(r.table.Name, specstore.OperationDelete).Add(float64(len(markedRowUIDs)))
+
+	return len(markedRowUIDs), nil
+}
@@ -0,0 +1,432 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	policiesv1 "github.com/open-cluster-management/governance-policy-propagator/api/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/database"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	testPolicyUID        = "4f1c7b8e-2a3d-4e5f-9a0b-1c2d3e4f5a6b"
+	testEventsBufferSize = 20
+)
+
+// newTestPolicyReconciler creates a policies reconciler of the store, with a fake client of the given objects instead
+// of a manager. the reconcilers of different fake clients act as syncers that see different copies of the instances,
+// e.g. an old and a new leader.
+func newTestPolicyReconciler(t *testing.T, store specstore.SpecStore,
+	objects ...client.Object) *genericSpecToDBReconciler {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+	if err := policiesv1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to add the policies scheme: %v", err)
+	}
+
+	return &genericSpecToDBReconciler{
+		client:             fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		eventRecorder:      &record.FakeRecorder{},
+		log:                logr.Discard(),
+		specStore:          store,
+		typeName:           "policies",
+		table:              database.SpecTable{Schema: database.DefaultSchema, Name: "policies"},
+		finalizerName:      hohCleanupFinalizer,
+		createInstance:     func() client.Object { return &policiesv1.Policy{} },
+		createInstanceList: func() client.ObjectList { return &policiesv1.PolicyList{} },
+		cleanStatus:        cleanPolicyStatus,
+		areEqual:           arePoliciesEqual,
+	}
+}
+
+func newTestPolicy(resourceVersion string, disabled bool) *policiesv1.Policy {
+	return &policiesv1.Policy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "policy",
+			Namespace:       "default",
+			UID:             testPolicyUID,
+			ResourceVersion: resourceVersion,
+			Finalizers:      []string{hohCleanupFinalizer},
+		},
+		Spec: policiesv1.PolicySpec{Disabled: disabled},
+	}
+}
+
+func reconcileTestPolicy(t *testing.T, reconciler *genericSpecToDBReconciler) ctrl.Result {
+	t.Helper()
+
+	result, err := reconciler.Reconcile(context.Background(), ctrl.Request{
+		NamespacedName: types.NamespacedName{Namespace: "default", Name: "policy"},
+	})
+	if err != nil {
+		t.Errorf("reconcile failed: %v", err)
+	}
+
+	return result
+}
+
+func getTestPolicyRow(t *testing.T, reconciler *genericSpecToDBReconciler) (*specstore.Row, *policiesv1.Policy) {
+	t.Helper()
+
+	row, err := reconciler.specStore.Get(context.Background(), reconciler.table, testPolicyUID)
+	if err != nil {
+		t.Fatalf("failed to get the row of the policy: %v", err)
+	}
+
+	policy := &policiesv1.Policy{}
+	if err := json.Unmarshal(row.Payload, policy); err != nil {
+		t.Fatalf("failed to unmarshal the payload of the policy: %v", err)
+	}
+
+	return row, policy
+}
+
+func TestConcurrentReconcilesOfOldAndNewLeaders(t *testing.T) {
+	const reconciles = 10
+
+	store := specstore.NewInMemorySpecStore()
+	// the resource versions are far apart, since the sync status patches bump them in each fake client
+	oldLeader := newTestPolicyReconciler(t, store, newTestPolicy("10", false))
+	newLeader := newTestPolicyReconciler(t, store, newTestPolicy("100", true))
+
+	var waitGroup sync.WaitGroup
+
+	for i := 0; i < reconciles; i++ {
+		for _, reconciler := range []*genericSpecToDBReconciler{oldLeader, newLeader} {
+			waitGroup.Add(1)
+
+			go func(reconciler *genericSpecToDBReconciler) {
+				defer waitGroup.Done()
+
+				reconcileTestPolicy(t, reconciler)
+			}(reconciler)
+		}
+	}
+
+	waitGroup.Wait()
+
+	// the last write wins, the next reconcile of the new leader writes its copy again
+	reconcileTestPolicy(t, newLeader)
+
+	row, policy := getTestPolicyRow(t, newLeader)
+
+	if !policy.Spec.Disabled || row.HubResourceVersion < 100 {
+		t.Errorf("expected the copy of the new leader, got hub resource version %d with disabled %t",
+			row.HubResourceVersion, policy.Spec.Disabled)
+	}
+}
+
+func TestStaleReconcileAfterNewLeaderIsAConflict(t *testing.T) {
+	store := specstore.NewInMemorySpecStore()
+	oldLeader := newTestPolicyReconciler(t, store, newTestPolicy("10", false))
+	newLeader := newTestPolicyReconciler(t, store, newTestPolicy("100", true))
+
+	eventRecorder := record.NewFakeRecorder(testEventsBufferSize)
+	oldLeader.eventRecorder = eventRecorder
+
+	reconcileTestPolicy(t, newLeader)
+
+	if result := reconcileTestPolicy(t, oldLeader); result.Requeue {
+		t.Error("expected the stale reconcile not to be requeued")
+	}
+
+	if _, policy := getTestPolicyRow(t, newLeader); policy.Spec.Disabled {
+		t.Error("expected the stale reconcile to overwrite the copy of the new leader")
+	}
+
+	if !hasTestEvent(eventRecorder, corev1.EventTypeWarning+" "+eventReasonConflict) {
+		t.Errorf("expected a %s event", eventReasonConflict)
+	}
+
+	reconcileTestPolicy(t, newLeader)
+
+	if _, policy := getTestPolicyRow(t, newLeader); !policy.Spec.Disabled {
+		t.Error("expected the next reconcile of the new leader to write its copy again")
+	}
+}
+
+// hasTestEvent returns true if the recorded events include an event that starts with the prefix, e.g.
+// "Warning Conflict".
+func hasTestEvent(eventRecorder *record.FakeRecorder, prefix string) bool {
+	for {
+		select {
+		case recordedEvent := <-eventRecorder.Events:
+			if strings.HasPrefix(recordedEvent, prefix) {
+				return true
+			}
+		default:
+			return false
+		}
+	}
+}
+
+var errTestDatabaseUnavailable = errors.New("the database is unavailable")
+
+// unavailableSpecStore fails all the writes of the spec store.
+type unavailableSpecStore struct {
+	specstore.SpecStore
+}
+
+func (s *unavailableSpecStore) Upsert(context.Context, database.SpecTable, *specstore.Row,
+	func([]byte) (bool, error)) (*specstore.UpsertResult, error) {
+	return nil, errTestDatabaseUnavailable
+}
+
+func (s *unavailableSpecStore) MarkDeleted(context.Context, database.SpecTable, specstore.RowFilter,
+	int64) ([]string, error) {
+	return nil, errTestDatabaseUnavailable
+}
+
+func TestFailedDeletionSetsFailedSyncStatus(t *testing.T) {
+	policy := newTestPolicy("10", false)
+	policy.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+
+	reconciler := newTestPolicyReconciler(t, &unavailableSpecStore{SpecStore: specstore.NewInMemorySpecStore()},
+		policy)
+
+	if _, err := reconciler.Reconcile(context.Background(), ctrl.Request{
+		NamespacedName: types.NamespacedName{Namespace: "default", Name: "policy"},
+	}); !errors.Is(err, errTestDatabaseUnavailable) {
+		t.Errorf("expected the error of the spec store, got %v", err)
+	}
+
+	policyOnHub := &policiesv1.Policy{}
+	if err := reconciler.client.Get(context.Background(), client.ObjectKeyFromObject(policy),
+		policyOnHub); err != nil {
+		t.Fatalf("failed to get the policy: %v", err)
+	}
+
+	if status := policyOnHub.GetAnnotations()[syncStatusAnnotation]; status != syncStatusFailed {
+		t.Errorf("expected sync status %s, got %q", syncStatusFailed, status)
+	}
+
+	if reason := policyOnHub.GetAnnotations()[syncErrorAnnotation]; reason != eventReasonDatabaseError {
+		t.Errorf("expected sync error %s, got %q", eventReasonDatabaseError, reason)
+	}
+
+	if !controllerutil.ContainsFinalizer(policyOnHub, hohCleanupFinalizer) {
+		t.Error("expected the finalizer to be kept until the rows are marked as deleted")
+	}
+}
+
+func TestDeletionMarksOnlyTheRowOfTheUIDAsDeleted(t *testing.T) {
+	const recreatedPolicyUID = "8d9e0f1a-2b3c-4d4e-9f5a-6b7c8d9e0f1a"
+
+	policy := newTestPolicy("10", false)
+	policy.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+
+	reconciler := newTestPolicyReconciler(t, specstore.NewInMemorySpecStore(), policy)
+
+	// the policy was recreated with the same name, and the new policy was synced before the deletion of the old one
+	upsertTestRow(t, reconciler, testPolicyUID, "policy")
+	upsertTestRow(t, reconciler, recreatedPolicyUID, "policy")
+
+	reconcileTestPolicy(t, reconciler)
+
+	row, err := reconciler.specStore.Get(context.Background(), reconciler.table, recreatedPolicyUID)
+	if err != nil {
+		t.Fatalf("failed to get the row of the recreated policy: %v", err)
+	}
+
+	if row.Deleted {
+		t.Error("expected the row of the recreated policy not to be deleted")
+	}
+
+	// the fake client removes the policy once its finalizer is removed
+	if err := reconciler.client.Get(context.Background(), client.ObjectKeyFromObject(policy),
+		&policiesv1.Policy{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the finalizer of the deleted policy to be removed, got %v", err)
+	}
+}
+
+func TestLastSuccessfulSyncTimeIsLabelledByType(t *testing.T) {
+	reconciler := newTestPolicyReconciler(t, specstore.NewInMemorySpecStore(), newTestPolicy("10", false))
+	reconciler.typeName = "test-policies"
+	reconciler.table.Name = "hub_policies"
+
+	reconcileTestPolicy(t, reconciler)
+
+	if syncTime := testutil.ToFloat64(lastSuccessfulSyncTime.WithLabelValues("test-policies")); syncTime == 0 {
+		t.Error("expected the last successful sync time of the type to be set")
+	}
+}
+
+func getTestPolicy(t *testing.T, reconciler *genericSpecToDBReconciler) *policiesv1.Policy {
+	t.Helper()
+
+	policy := &policiesv1.Policy{}
+	if err := reconciler.client.Get(context.Background(),
+		types.NamespacedName{Namespace: "default", Name: "policy"}, policy); err != nil {
+		t.Fatalf("failed to get the policy: %v", err)
+	}
+
+	return policy
+}
+
+// updateTestPolicy gets the policy from the fake client of the reconciler, changes it and updates it.
+func updateTestPolicy(t *testing.T, reconciler *genericSpecToDBReconciler,
+	change func(policy *policiesv1.Policy)) *policiesv1.Policy {
+	t.Helper()
+
+	policy := getTestPolicy(t, reconciler)
+	change(policy)
+
+	if err := reconciler.client.Update(context.Background(), policy); err != nil {
+		t.Fatalf("failed to update the policy: %v", err)
+	}
+
+	return policy
+}
+
+func checkTestPolicyRow(t *testing.T, reconciler *genericSpecToDBReconciler, expectedRevision int64,
+	expectedDeleted bool) {
+	t.Helper()
+
+	if row, _ := getTestPolicyRow(t, reconciler); row.Revision != expectedRevision || row.Deleted != expectedDeleted {
+		t.Errorf("expected revision %d with deleted %t, got revision %d with deleted %t", expectedRevision,
+			expectedDeleted, row.Revision, row.Deleted)
+	}
+}
+
+func TestInstanceIsSyncedUntilDeleted(t *testing.T) {
+	reconciler := newTestPolicyReconciler(t, specstore.NewInMemorySpecStore(), newTestPolicy("10", false))
+
+	reconcileTestPolicy(t, reconciler)
+	checkTestPolicyRow(t, reconciler, 1, false)
+
+	annotations := getTestPolicy(t, reconciler).GetAnnotations()
+	if annotations[syncStatusAnnotation] != syncStatusSynced || annotations[databaseIDAnnotation] != testPolicyUID ||
+		annotations[databaseRevisionAnnotation] != "1" {
+		t.Errorf("expected the synced sync status of revision 1, got the annotations %v", annotations)
+	}
+
+	// the reconcile triggered by the sync status patch does not change the row
+	reconcileTestPolicy(t, reconciler)
+	checkTestPolicyRow(t, reconciler, 1, false)
+
+	updateTestPolicy(t, reconciler, func(policy *policiesv1.Policy) { policy.Spec.Disabled = true })
+	reconcileTestPolicy(t, reconciler)
+	checkTestPolicyRow(t, reconciler, 2, false)
+
+	if _, policy := getTestPolicyRow(t, reconciler); !policy.Spec.Disabled {
+		t.Error("expected the row to have the updated policy")
+	}
+
+	if err := reconciler.client.Delete(context.Background(), newTestPolicy("", false)); err != nil {
+		t.Fatalf("failed to delete the policy: %v", err)
+	}
+
+	reconcileTestPolicy(t, reconciler)
+	checkTestPolicyRow(t, reconciler, 3, true)
+}
+
+func TestExcludedInstanceIsDeletedUntilIncludedAgain(t *testing.T) {
+	reconciler := newTestPolicyReconciler(t, specstore.NewInMemorySpecStore(), newTestPolicy("10", false))
+
+	reconcileTestPolicy(t, reconciler)
+	checkTestPolicyRow(t, reconciler, 1, false)
+
+	updateTestPolicy(t, reconciler, func(policy *policiesv1.Policy) {
+		policy.SetAnnotations(map[string]string{skipSyncAnnotation: "true"})
+	})
+	reconcileTestPolicy(t, reconciler)
+	checkTestPolicyRow(t, reconciler, 2, true)
+
+	policy := updateTestPolicy(t, reconciler, func(policy *policiesv1.Policy) {
+		policy.SetAnnotations(nil)
+	})
+	if controllerutil.ContainsFinalizer(policy, hohCleanupFinalizer) {
+		t.Error("expected the finalizer of the excluded policy to be removed")
+	}
+
+	// the same payload is synced again, the row is updated as not deleted
+	reconcileTestPolicy(t, reconciler)
+	checkTestPolicyRow(t, reconciler, 3, false)
+
+	policy = getTestPolicy(t, reconciler)
+	if !controllerutil.ContainsFinalizer(policy, hohCleanupFinalizer) ||
+		policy.GetAnnotations()[databaseRevisionAnnotation] != "3" {
+		t.Errorf("expected the finalizer and the sync status of revision 3, got the finalizers %v and the "+
+			"annotations %v", policy.GetFinalizers(), policy.GetAnnotations())
+	}
+}
+
+func TestInstanceExcludedByLabelSelectorIsDeleted(t *testing.T) {
+	reconciler := newTestPolicyReconciler(t, specstore.NewInMemorySpecStore(), newTestPolicy("10", false))
+	reconciler.labelSelector = labels.SelectorFromSet(labels.Set{"environment": "production"})
+
+	updateTestPolicy(t, reconciler, func(policy *policiesv1.Policy) {
+		policy.SetLabels(map[string]string{"environment": "production"})
+	})
+	reconcileTestPolicy(t, reconciler)
+	checkTestPolicyRow(t, reconciler, 1, false)
+
+	updateTestPolicy(t, reconciler, func(policy *policiesv1.Policy) {
+		policy.SetLabels(map[string]string{"environment": "staging"})
+	})
+	reconcileTestPolicy(t, reconciler)
+	checkTestPolicyRow(t, reconciler, 2, true)
+
+	if controllerutil.ContainsFinalizer(getTestPolicy(t, reconciler), hohCleanupFinalizer) {
+		t.Error("expected the finalizer of the excluded policy to be removed")
+	}
+
+	// the reconciles of an excluded instance without a finalizer change nothing
+	reconcileTestPolicy(t, reconciler)
+	checkTestPolicyRow(t, reconciler, 2, true)
+}
+
+func TestInstanceExcludedOnCreationIsNotSynced(t *testing.T) {
+	policy := newTestPolicy("10", false)
+	policy.Finalizers = nil
+
+	reconciler := newTestPolicyReconciler(t, specstore.NewInMemorySpecStore(), policy)
+	reconciler.namespaceFilters = []*NamespaceFilter{{Exclude: []string{"def*"}}}
+
+	reconcileTestPolicy(t, reconciler)
+
+	if _, err := reconciler.specStore.Get(context.Background(), reconciler.table,
+		testPolicyUID); !errors.Is(err, specstore.ErrRowNotFound) {
+		t.Errorf("expected no row of the excluded policy, got %v", err)
+	}
+
+	if controllerutil.ContainsFinalizer(getTestPolicy(t, reconciler), hohCleanupFinalizer) {
+		t.Error("expected no finalizer on the excluded policy")
+	}
+}
+
+func TestSyncErrorReason(t *testing.T) {
+	for err, expectedReason := range map[error]string{
+		fmt.Errorf("%w: failed to add %s: %v", errFinalizerUpdate, hohCleanupFinalizer,
+			errTestDatabaseUnavailable): eventReasonFinalizerError,
+		fmt.Errorf("failed to mark the rows as deleted: %w", errTestDatabaseUnavailable): eventReasonDatabaseError,
+	} {
+		if reason := getSyncErrorReason(err); reason != expectedReason {
+			t.Errorf("expected reason %s of %v, got %s", expectedReason, err, reason)
+		}
+	}
+}
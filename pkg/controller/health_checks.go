@@ -0,0 +1,116 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const healthCheckTimeout = 5 * time.Second
+
+var (
+	errCacheNotSyncedYet = errors.New("the cache is not synced yet")
+	errReconcileStuck    = errors.New("reconcile stuck")
+)
+
+//nolint:gochecknoglobals // the reconciles of all the tables are tracked together
+var inFlightReconciles = &reconcilesTracker{startTimes: make(map[*struct{}]reconcileStart)}
+
+type reconcileStart struct {
+	tableName string
+	time      time.Time
+}
+
+// reconcilesTracker tracks the start times of the reconciles in flight of all the tables.
+type reconcilesTracker struct {
+	mutex      sync.Mutex
+	startTimes map[*struct{}]reconcileStart
+}
+
+// start records the start of a reconcile of the table and returns the function that records its end.
+func (t *reconcilesTracker) start(tableName string) func() {
+	key := &struct{}{}
+
+	t.mutex.Lock()
+	t.startTimes[key] = reconcileStart{tableName: tableName, time: time.Now()}
+	t.mutex.Unlock()
+
+	return func() {
+		t.mutex.Lock()
+		delete(t.startTimes, key)
+		t.mutex.Unlock()
+	}
+}
+
+// oldest returns the table and the start time of the oldest reconcile in flight, if any.
+func (t *reconcilesTracker) oldest() (reconcileStart, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var oldest reconcileStart
+
+	found := false
+
+	for _, start := range t.startTimes {
+		if !found || start.time.Before(oldest.time) {
+			oldest = start
+			found = true
+		}
+	}
+
+	return oldest, found
+}
+
+// AddHealthChecks adds to the Manager a readiness check that pings the spec store and checks that the cache is synced,
+// and a liveness check that fails once a reconcile has been running for longer than the stuck reconcile timeout.
+func AddHealthChecks(mgr ctrl.Manager, specStore specstore.SpecStore,
+	stuckReconcileTimeout time.Duration) error {
+	if err := mgr.AddReadyzCheck("database", func(request *http.Request) error {
+		ctx, cancel := context.WithTimeout(request.Context(), healthCheckTimeout)
+		defer cancel()
+
+		if err := specStore.Ping(ctx); err != nil {
+			return fmt.Errorf("failed to ping the spec store: %w", err)
+		}
+
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to add database readiness check: %w", err)
+	}
+
+	if err := mgr.AddReadyzCheck("cache", func(request *http.Request) error {
+		ctx, cancel := context.WithTimeout(request.Context(), healthCheckTimeout)
+		defer cancel()
+
+		if !mgr.GetCache().WaitForCacheSync(ctx) {
+			return errCacheNotSyncedYet
+		}
+
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to add cache readiness check: %w", err)
+	}
+
+	if err := mgr.AddHealthzCheck("reconciles", func(*http.Request) error {
+		oldest, found := inFlightReconciles.oldest()
+		if found && time.Since(oldest.time) > stuckReconcileTimeout {
+			return fmt.Errorf("%w: a reconcile of %s has been running since %s", errReconcileStuck,
+				oldest.tableName, oldest.time.Format(time.RFC3339))
+		}
+
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to add reconciles liveness check: %w", err)
+	}
+
+	return nil
+}
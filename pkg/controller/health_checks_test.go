@@ -0,0 +1,141 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+const testStuckReconcileTimeout = time.Minute
+
+// checksManager records the health checks added to it instead of serving them.
+type checksManager struct {
+	ctrl.Manager
+	cache           cache.Cache
+	readinessChecks map[string]healthz.Checker
+	livenessChecks  map[string]healthz.Checker
+}
+
+func (m *checksManager) AddReadyzCheck(name string, check healthz.Checker) error {
+	m.readinessChecks[name] = check
+	return nil
+}
+
+func (m *checksManager) AddHealthzCheck(name string, check healthz.Checker) error {
+	m.livenessChecks[name] = check
+	return nil
+}
+
+func (m *checksManager) GetCache() cache.Cache {
+	return m.cache
+}
+
+// syncedCache is a cache that is synced or not.
+type syncedCache struct {
+	cache.Cache
+	synced bool
+}
+
+func (c *syncedCache) WaitForCacheSync(context.Context) bool {
+	return c.synced
+}
+
+// unreachableSpecStore fails the pings of the spec store.
+type unreachableSpecStore struct {
+	specstore.SpecStore
+}
+
+func (s *unreachableSpecStore) Ping(context.Context) error {
+	return errTestDatabaseUnavailable
+}
+
+func addTestHealthChecks(t *testing.T, specStore specstore.SpecStore, cacheSynced bool) *checksManager {
+	t.Helper()
+
+	mgr := &checksManager{
+		cache:           &syncedCache{synced: cacheSynced},
+		readinessChecks: map[string]healthz.Checker{},
+		livenessChecks:  map[string]healthz.Checker{},
+	}
+
+	if err := AddHealthChecks(mgr, specStore, testStuckReconcileTimeout); err != nil {
+		t.Fatalf("failed to add the health checks: %v", err)
+	}
+
+	return mgr
+}
+
+func runTestCheck(t *testing.T, checks map[string]healthz.Checker, name string) error {
+	t.Helper()
+
+	check, found := checks[name]
+	if !found {
+		t.Fatalf("expected the check %s", name)
+	}
+
+	return check(httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestReadinessChecks(t *testing.T) {
+	mgr := addTestHealthChecks(t, specstore.NewInMemorySpecStore(), true)
+
+	for _, name := range []string{"database", "cache"} {
+		if err := runTestCheck(t, mgr.readinessChecks, name); err != nil {
+			t.Errorf("expected the %s check to pass, got %v", name, err)
+		}
+	}
+
+	mgr = addTestHealthChecks(t, &unreachableSpecStore{SpecStore: specstore.NewInMemorySpecStore()}, false)
+
+	if err := runTestCheck(t, mgr.readinessChecks, "database"); !errors.Is(err, errTestDatabaseUnavailable) {
+		t.Errorf("expected the database check to fail with the error of the spec store, got %v", err)
+	}
+
+	if err := runTestCheck(t, mgr.readinessChecks, "cache"); !errors.Is(err, errCacheNotSyncedYet) {
+		t.Errorf("expected the cache check to fail until the cache is synced, got %v", err)
+	}
+}
+
+func TestStuckReconcileLivenessCheck(t *testing.T) {
+	mgr := addTestHealthChecks(t, specstore.NewInMemorySpecStore(), true)
+
+	end := inFlightReconciles.start("policies")
+	defer end()
+
+	if err := runTestCheck(t, mgr.livenessChecks, "reconciles"); err != nil {
+		t.Errorf("expected the reconciles check to pass while the reconcile is in time, got %v", err)
+	}
+
+	// a reconcile that started before the timeout is stuck
+	stuckKey := &struct{}{}
+
+	inFlightReconciles.mutex.Lock()
+	inFlightReconciles.startTimes[stuckKey] = reconcileStart{
+		tableName: "subscriptions",
+		time:      time.Now().Add(-2 * testStuckReconcileTimeout),
+	}
+	inFlightReconciles.mutex.Unlock()
+
+	if err := runTestCheck(t, mgr.livenessChecks, "reconciles"); !errors.Is(err, errReconcileStuck) {
+		t.Errorf("expected the reconciles check to fail once a reconcile is stuck, got %v", err)
+	}
+
+	inFlightReconciles.mutex.Lock()
+	delete(inFlightReconciles.startTimes, stuckKey)
+	inFlightReconciles.mutex.Unlock()
+
+	if err := runTestCheck(t, mgr.livenessChecks, "reconciles"); err != nil {
+		t.Errorf("expected the reconciles check to pass once the stuck reconcile ended, got %v", err)
+	}
+}
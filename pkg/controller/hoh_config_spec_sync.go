@@ -6,37 +6,52 @@ package controller
 import (
 	"fmt"
 
-	"github.com/jackc/pgx/v4/pgxpool"
 	configv1 "github.com/stolostron/hub-of-hubs-data-types/apis/config/v1"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
 	"k8s.io/apimachinery/pkg/api/equality"
 	ctrl "sigs.k8s.io/controller-runtime"
 	client "sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const (
+	// only the configs in hohSystemNamespace are synced, unless configured otherwise
 	hohSystemNamespace = "hoh-system"
 )
 
-func addHubOfHubsConfigController(mgr ctrl.Manager, databaseConnectionPool *pgxpool.Pool) error {
+func addHubOfHubsConfigController(mgr ctrl.Manager, specStore specstore.SpecStore, config *Config,
+	outageDetector *databaseOutageDetector) error {
+	reconciler := &genericSpecToDBReconciler{
+		client:             mgr.GetClient(),
+		eventRecorder:      mgr.GetEventRecorderFor(eventRecorderName),
+		specStore:          specStore,
+		log:                ctrl.Log.WithName("hoh-configs-spec-syncer"),
+		typeName:           "configs",
+		table:              config.specTable("configs"),
+		finalizerName:      hohCleanupFinalizer,
+		createInstance:     func() client.Object { return &configv1.Config{} },
+		createInstanceList: func() client.ObjectList { return &configv1.ConfigList{} },
+		cleanStatus:        cleanConfigStatus,
+		areEqual:           areConfigsEqual,
+		labelSelector:      config.labelSelector("configs"),
+		namespaceFilters:   config.namespaceFilters("configs", &NamespaceFilter{Include: []string{hohSystemNamespace}}),
+		resyncEvents:       make(chan event.GenericEvent),
+	}
+
 	if err := ctrl.NewControllerManagedBy(mgr).
 		For(&configv1.Config{}).
-		WithEventFilter(predicate.NewPredicateFuncs(func(object client.Object) bool {
-			return object.GetNamespace() == hohSystemNamespace
-		})).
-		Complete(&genericSpecToDBReconciler{
-			client:                 mgr.GetClient(),
-			databaseConnectionPool: databaseConnectionPool,
-			log:                    ctrl.Log.WithName("hoh-configs-spec-syncer"),
-			tableName:              "configs",
-			finalizerName:          hohCleanupFinalizer,
-			createInstance:         func() client.Object { return &configv1.Config{} },
-			cleanStatus:            cleanConfigStatus,
-			areEqual:               areConfigsEqual,
-		}); err != nil {
+		WithEventFilter(reconciler.namespacePredicate()).
+		Watches(&source.Channel{Source: reconciler.resyncEvents}, &handler.EnqueueRequestForObject{}).
+		Complete(reconciler); err != nil {
 		return fmt.Errorf("failed to add hoh config controller to the manager: %w", err)
 	}
 
+	if err := addDatabaseMaintainers(mgr, reconciler, config, outageDetector); err != nil {
+		return fmt.Errorf("failed to add hoh config database maintainers to the manager: %w", err)
+	}
+
 	return nil
 }
 
@@ -6,29 +6,47 @@ package controller
 import (
 	"fmt"
 
-	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
 	"k8s.io/apimachinery/pkg/api/equality"
 	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
-func addManagedClusterSetController(mgr ctrl.Manager, databaseConnectionPool *pgxpool.Pool) error {
+func addManagedClusterSetController(mgr ctrl.Manager, specStore specstore.SpecStore, config *Config,
+	outageDetector *databaseOutageDetector) error {
+	reconciler := &genericSpecToDBReconciler{
+		client:             mgr.GetClient(),
+		eventRecorder:      mgr.GetEventRecorderFor(eventRecorderName),
+		specStore:          specStore,
+		log:                ctrl.Log.WithName("managedclustersets-spec-syncer"),
+		typeName:           "managedclustersets",
+		table:              config.specTable("managedclustersets"),
+		finalizerName:      hohCleanupFinalizer,
+		createInstance:     func() client.Object { return &clusterv1beta1.ManagedClusterSet{} },
+		createInstanceList: func() client.ObjectList { return &clusterv1beta1.ManagedClusterSetList{} },
+		cleanStatus:        cleanManagedClusterSetStatus,
+		areEqual:           areManagedClusterSetsEqual,
+		labelSelector:      config.labelSelector("managedclustersets"),
+		namespaceFilters:   config.namespaceFilters("managedclustersets", nil),
+		resyncEvents:       make(chan event.GenericEvent),
+	}
+
 	if err := ctrl.NewControllerManagedBy(mgr).
 		For(&clusterv1beta1.ManagedClusterSet{}).
-		Complete(&genericSpecToDBReconciler{
-			client:                 mgr.GetClient(),
-			databaseConnectionPool: databaseConnectionPool,
-			log:                    ctrl.Log.WithName("managedclustersets-spec-syncer"),
-			tableName:              "managedclustersets",
-			finalizerName:          hohCleanupFinalizer,
-			createInstance:         func() client.Object { return &clusterv1beta1.ManagedClusterSet{} },
-			cleanStatus:            cleanManagedClusterSetStatus,
-			areEqual:               areManagedClusterSetsEqual,
-		}); err != nil {
+		WithEventFilter(reconciler.namespacePredicate()).
+		Watches(&source.Channel{Source: reconciler.resyncEvents}, &handler.EnqueueRequestForObject{}).
+		Complete(reconciler); err != nil {
 		return fmt.Errorf("failed to add managed cluster set controller to the manager: %w", err)
 	}
 
+	if err := addDatabaseMaintainers(mgr, reconciler, config, outageDetector); err != nil {
+		return fmt.Errorf("failed to add managed cluster set database maintainers to the manager: %w", err)
+	}
+
 	return nil
 }
 
@@ -6,29 +6,47 @@ package controller
 import (
 	"fmt"
 
-	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
 	"k8s.io/apimachinery/pkg/api/equality"
 	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
-func addManagedClusterSetBindingController(mgr ctrl.Manager, databaseConnectionPool *pgxpool.Pool) error {
+func addManagedClusterSetBindingController(mgr ctrl.Manager, specStore specstore.SpecStore, config *Config,
+	outageDetector *databaseOutageDetector) error {
+	reconciler := &genericSpecToDBReconciler{
+		client:             mgr.GetClient(),
+		eventRecorder:      mgr.GetEventRecorderFor(eventRecorderName),
+		specStore:          specStore,
+		log:                ctrl.Log.WithName("managedclustersetbindings-spec-syncer"),
+		typeName:           "managedclustersetbindings",
+		table:              config.specTable("managedclustersetbindings"),
+		finalizerName:      hohCleanupFinalizer,
+		createInstance:     func() client.Object { return &clusterv1beta1.ManagedClusterSetBinding{} },
+		createInstanceList: func() client.ObjectList { return &clusterv1beta1.ManagedClusterSetBindingList{} },
+		cleanStatus:        cleanManagedClusterSetBindingsStatus,
+		areEqual:           areManagedClusterSetBindingsEqual,
+		labelSelector:      config.labelSelector("managedclustersetbindings"),
+		namespaceFilters:   config.namespaceFilters("managedclustersetbindings", nil),
+		resyncEvents:       make(chan event.GenericEvent),
+	}
+
 	if err := ctrl.NewControllerManagedBy(mgr).
 		For(&clusterv1beta1.ManagedClusterSetBinding{}).
-		Complete(&genericSpecToDBReconciler{
-			client:                 mgr.GetClient(),
-			databaseConnectionPool: databaseConnectionPool,
-			log:                    ctrl.Log.WithName("managedclustersetbindings-spec-syncer"),
-			tableName:              "managedclustersetbindings",
-			finalizerName:          hohCleanupFinalizer,
-			createInstance:         func() client.Object { return &clusterv1beta1.ManagedClusterSetBinding{} },
-			cleanStatus:            cleanManagedClusterSetBindingsStatus,
-			areEqual:               areManagedClusterSetBindingsEqual,
-		}); err != nil {
+		WithEventFilter(reconciler.namespacePredicate()).
+		Watches(&source.Channel{Source: reconciler.resyncEvents}, &handler.EnqueueRequestForObject{}).
+		Complete(reconciler); err != nil {
 		return fmt.Errorf("failed to add managed cluster set binding controller to the manager: %w", err)
 	}
 
+	if err := addDatabaseMaintainers(mgr, reconciler, config, outageDetector); err != nil {
+		return fmt.Errorf("failed to add managed cluster set binding database maintainers to the manager: %w", err)
+	}
+
 	return nil
 }
 
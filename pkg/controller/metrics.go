@@ -0,0 +1,76 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	metricsNamespace = "hub_of_hubs_spec_sync"
+
+	rowStateLive    = "live"
+	rowStateDeleted = "deleted"
+)
+
+//nolint:gochecknoglobals // the metrics are registered once
+var (
+	activeTypes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "active_types",
+		Help:      "Whether the controller of an enabled type is active (1) or waiting for its CRD to be installed (0).",
+	}, []string{"type"})
+
+	rowsWritten = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rows_written_total",
+		Help:      "Number of rows inserted, updated or marked as deleted (tombstoned), by operation.",
+	}, []string{"table", "operation"})
+
+	syncFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sync_failures_total",
+		Help:      "Number of failed reconciles.",
+	}, []string{"table"})
+
+	rows = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "rows",
+		Help: "Number of live and deleted rows, updated by the orphaned rows sweeps, every " +
+			orphanedRowsSweepPeriod.String() + ", so it lags behind the writes.",
+	}, []string{"table", "state"})
+
+	lastSuccessfulSyncTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_successful_sync_timestamp_seconds",
+		Help:      "Unix time of the last successful reconcile, by type.",
+	}, []string{"type"})
+
+	databaseAvailable = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "database_available",
+		Help:      "Whether the database was available on the last outage detection (1) or not (0).",
+	})
+
+	purgedTombstones = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "purged_tombstones_total",
+		Help:      "Number of rows marked as deleted that were hard-deleted after their retention period.",
+	}, []string{"table"})
+
+	unacknowledgedTombstones = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "unacknowledged_tombstones",
+		Help: "Number of rows marked as deleted past their retention period, waiting for the acknowledgement " +
+			"of all the consumers of their table.",
+	}, []string{"table"})
+)
+
+//nolint:gochecknoinits // the metrics are registered once
+func init() {
+	// register with the registry of controller-runtime, so that the metrics are served by the manager
+	metrics.Registry.MustRegister(activeTypes, rowsWritten, syncFailures, rows,
+		lastSuccessfulSyncTime, databaseAvailable, purgedTombstones, unacknowledgedTombstones)
+}
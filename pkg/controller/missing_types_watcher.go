@@ -0,0 +1,102 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const missingTypesPollPeriod = 30 * time.Second
+
+// missingTypesWatcher polls the discovery API until the CRDs of the enabled types that were missing on startup are
+// installed, and adds the controllers of the types once their CRDs are installed.
+type missingTypesWatcher struct {
+	mgr             ctrl.Manager
+	specStore       specstore.SpecStore
+	config          *Config
+	outageDetector  *databaseOutageDetector
+	discoveryClient discovery.DiscoveryInterface
+	missingTypes    []syncedType
+	period          time.Duration
+}
+
+// Start polls until all the missing types are active or the context is done. the watcher requires leader election,
+// so the controllers it adds are started right away. it returns an error, which stops the manager, if it fails to add
+// a controller: the controller may have been registered with the manager before the failure, so adding it again could
+// register a duplicate controller, and the syncer restarts instead.
+func (w *missingTypesWatcher) Start(ctx context.Context) error {
+	if err := wait.PollImmediateUntil(w.period, func() (bool, error) {
+		if err := w.addInstalledTypes(); err != nil {
+			return false, err
+		}
+
+		return len(w.missingTypes) == 0, nil
+	}, ctx.Done()); err != nil && !errors.Is(err, wait.ErrWaitTimeout) {
+		return fmt.Errorf("failed to add the controllers of the missing types: %w", err)
+	}
+
+	return nil
+}
+
+// addInstalledTypes adds the controllers of the missing types that are installed. a type is removed from the missing
+// types before its controller is added, so that its controller is never added twice.
+func (w *missingTypesWatcher) addInstalledTypes() error {
+	stillMissingTypes := make([]syncedType, 0, len(w.missingTypes))
+
+	for i, syncedType := range w.missingTypes {
+		log := ctrl.Log.WithName(syncedType.logName)
+
+		installed, err := isTypeInstalled(w.discoveryClient, syncedType.gvk)
+		if err != nil {
+			log.Error(err, "Failed to discover the CRD", "kind", syncedType.gvk)
+		}
+
+		if !installed {
+			stillMissingTypes = append(stillMissingTypes, syncedType)
+			continue
+		}
+
+		if err := syncedType.addController(w.mgr, w.specStore, w.config, w.outageDetector); err != nil {
+			w.missingTypes = append(stillMissingTypes, w.missingTypes[i+1:]...)
+			return fmt.Errorf("failed to add the controller of %s: %w", syncedType.gvk, err)
+		}
+
+		log.Info("The CRD has been installed, the controller is active", "kind", syncedType.gvk)
+		activeTypes.WithLabelValues(syncedType.name).Set(1)
+	}
+
+	w.missingTypes = stillMissingTypes
+
+	return nil
+}
+
+// isTypeInstalled checks by the discovery API if the given kind is served.
+func isTypeInstalled(discoveryClient discovery.DiscoveryInterface, gvk schema.GroupVersionKind) (bool, error) {
+	resources, err := discoveryClient.ServerResourcesForGroupVersion(gvk.GroupVersion().String())
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("failed to get the server resources of %s: %w", gvk.GroupVersion(), err)
+	}
+
+	for _, resource := range resources.APIResources {
+		if resource.Kind == gvk.Kind {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var (
+	errTestDiscovery     = errors.New("the discovery failed")
+	errTestAddController = errors.New("failed to add the controller")
+)
+
+// testDiscoveryClient serves the kinds of its group versions, and fails with discoveryErr while it is set.
+type testDiscoveryClient struct {
+	discovery.DiscoveryInterface
+	kinds        map[string][]string
+	discoveryErr error
+}
+
+func (c *testDiscoveryClient) ServerResourcesForGroupVersion(groupVersion string) (*metav1.APIResourceList, error) {
+	if c.discoveryErr != nil {
+		return nil, c.discoveryErr
+	}
+
+	kinds, found := c.kinds[groupVersion]
+	if !found {
+		return nil, apierrors.NewNotFound(schema.GroupResource{}, groupVersion)
+	}
+
+	resources := &metav1.APIResourceList{GroupVersion: groupVersion}
+
+	for _, kind := range kinds {
+		resources.APIResources = append(resources.APIResources, metav1.APIResource{Kind: kind})
+	}
+
+	return resources, nil
+}
+
+// newTestMissingType returns a type whose controller additions are counted, and fail with addErr if it is set.
+func newTestMissingType(name, kind string, additions *int, addErr error) syncedType {
+	return syncedType{
+		name:    name,
+		logName: name + "-spec-syncer",
+		gvk:     schema.GroupVersionKind{Group: "apps.open-cluster-management.io", Version: "v1", Kind: kind},
+		addController: func(ctrl.Manager, specstore.SpecStore, *Config, *databaseOutageDetector) error {
+			*additions++
+			return addErr
+		},
+	}
+}
+
+func TestWatcherAddsTheControllersOfInstalledTypesOnce(t *testing.T) {
+	var channelAdditions, subscriptionAdditions int
+
+	discoveryClient := &testDiscoveryClient{
+		kinds: map[string][]string{"apps.open-cluster-management.io/v1": {"Channel"}},
+	}
+	watcher := &missingTypesWatcher{
+		discoveryClient: discoveryClient,
+		missingTypes: []syncedType{
+			newTestMissingType("channels", "Channel", &channelAdditions, nil),
+			newTestMissingType("subscriptions", "Subscription", &subscriptionAdditions, nil),
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := watcher.addInstalledTypes(); err != nil {
+			t.Fatalf("failed to add the installed types: %v", err)
+		}
+	}
+
+	if channelAdditions != 1 || subscriptionAdditions != 0 || len(watcher.missingTypes) != 1 {
+		t.Errorf("expected only the channels controller to be added once, got %d channels and %d subscriptions "+
+			"controllers with %d missing types", channelAdditions, subscriptionAdditions, len(watcher.missingTypes))
+	}
+
+	// a failed discovery keeps the type missing
+	discoveryClient.kinds["apps.open-cluster-management.io/v1"] = []string{"Channel", "Subscription"}
+	discoveryClient.discoveryErr = errTestDiscovery
+
+	if err := watcher.addInstalledTypes(); err != nil || subscriptionAdditions != 0 {
+		t.Fatalf("expected no subscriptions controller, got %d with error %v", subscriptionAdditions, err)
+	}
+
+	discoveryClient.discoveryErr = nil
+
+	if err := watcher.Start(context.Background()); err != nil {
+		t.Fatalf("failed to watch the missing types: %v", err)
+	}
+
+	if subscriptionAdditions != 1 || len(watcher.missingTypes) != 0 {
+		t.Errorf("expected the subscriptions controller to be added once, got %d with %d missing types",
+			subscriptionAdditions, len(watcher.missingTypes))
+	}
+}
+
+func TestWatcherStopsOnFailedControllerAddition(t *testing.T) {
+	var channelAdditions int
+
+	watcher := &missingTypesWatcher{
+		discoveryClient: &testDiscoveryClient{
+			kinds: map[string][]string{"apps.open-cluster-management.io/v1": {"Channel"}},
+		},
+		missingTypes: []syncedType{newTestMissingType("channels", "Channel", &channelAdditions, errTestAddController)},
+	}
+
+	if err := watcher.Start(context.Background()); !errors.Is(err, errTestAddController) {
+		t.Errorf("expected the error of the controller addition, got %v", err)
+	}
+
+	// the controller may be partially registered, so its addition is never retried
+	if err := watcher.addInstalledTypes(); err != nil || channelAdditions != 1 {
+		t.Errorf("expected a single addition of the channels controller, got %d with error %v", channelAdditions, err)
+	}
+}
+
+func TestTypesThatFailDiscoveryOnStartupAreWatched(t *testing.T) {
+	mgr := &runnablesManager{}
+
+	if err := addControllers(mgr, specstore.NewInMemorySpecStore(), &Config{EnabledTypes: []string{"channels"}},
+		&testDiscoveryClient{discoveryErr: errTestDiscovery}); err != nil {
+		t.Fatalf("expected the discovery error to be tolerated, got %v", err)
+	}
+
+	var watcher *missingTypesWatcher
+
+	for _, runnable := range mgr.runnables {
+		if missingTypesWatcher, ok := runnable.(*missingTypesWatcher); ok {
+			watcher = missingTypesWatcher
+		}
+	}
+
+	if watcher == nil || len(watcher.missingTypes) != 1 || watcher.missingTypes[0].name != "channels" {
+		t.Errorf("expected a watcher of the channels, got %+v", watcher)
+	}
+}
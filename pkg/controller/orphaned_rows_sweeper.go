@@ -0,0 +1,155 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const orphanedRowsSweepPeriod = 10 * time.Minute
+
+var (
+	errCacheNotSynced       = errors.New("failed to wait for the cache to sync")
+	errUnexpectedObjectType = errors.New("unexpected object type in the list")
+)
+
+// orphanedRowsSweeper marks as deleted the rows of the database that have no matching instance on hub (excluded
+// instances do not count), on startup and then periodically. such rows are left behind since reconciles are only
+// triggered by hub events, e.g. if an instance was deleted while the syncer was down, or if its finalizer was removed
+// manually.
+type orphanedRowsSweeper struct {
+	reconciler *genericSpecToDBReconciler
+	cache      cache.Cache
+	period     time.Duration
+}
+
+func addOrphanedRowsSweeper(mgr ctrl.Manager, reconciler *genericSpecToDBReconciler) error {
+	if err := mgr.Add(&orphanedRowsSweeper{
+		reconciler: reconciler,
+		cache:      mgr.GetCache(),
+		period:     orphanedRowsSweepPeriod,
+	}); err != nil {
+		return fmt.Errorf("failed to add orphaned rows sweeper: %w", err)
+	}
+
+	return nil
+}
+
+// Start runs the sweeps until the context is done. the sweeper requires leader election, like the controllers.
+func (s *orphanedRowsSweeper) Start(ctx context.Context) error {
+	if !s.cache.WaitForCacheSync(ctx) {
+		return errCacheNotSynced
+	}
+
+	wait.UntilWithContext(ctx, s.sweep, s.period)
+
+	return nil
+}
+
+func (s *orphanedRowsSweeper) sweep(ctx context.Context) {
+	log := s.reconciler.log.WithValues("table", s.reconciler.table.Name)
+
+	// the rows are read before the instances are listed, so that a row inserted for a new instance in the meantime is
+	// never considered orphaned
+	rowUIDs, err := s.getNotDeletedRowUIDs(ctx)
+	if err != nil {
+		log.Error(err, "Orphaned rows sweep failed")
+		return
+	}
+
+	instanceUIDs, err := s.getInstanceUIDs(ctx)
+	if err != nil {
+		log.Error(err, "Orphaned rows sweep failed")
+		return
+	}
+
+	orphanedRowUIDs := make([]string, 0)
+
+	for _, rowUID := range rowUIDs {
+		if _, found := instanceUIDs[rowUID]; !found {
+			orphanedRowUIDs = append(orphanedRowUIDs, rowUID)
+		}
+	}
+
+	markedAsDeleted, err := s.markRowsAsDeleted(ctx, orphanedRowUIDs)
+	if err != nil {
+		log.Error(err, "Orphaned rows sweep failed")
+		return
+	}
+
+	log.Info("Orphaned rows sweep complete", "not deleted rows", len(rowUIDs),
+		"instances on hub", len(instanceUIDs), "rows marked as deleted", markedAsDeleted)
+}
+
+// getNotDeletedRowUIDs returns the UIDs of the not deleted rows of the table, and updates the rows metric.
+func (s *orphanedRowsSweeper) getNotDeletedRowUIDs(ctx context.Context) ([]string, error) {
+	tableRows, err := s.reconciler.specStore.List(ctx, s.reconciler.table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the rows from the database: %w", err)
+	}
+
+	rowUIDs := make([]string, 0, len(tableRows))
+
+	for _, row := range tableRows {
+		if !row.Deleted {
+			rowUIDs = append(rowUIDs, row.ID)
+		}
+	}
+
+	rows.WithLabelValues(s.reconciler.table.Name, rowStateLive).Set(float64(len(rowUIDs)))
+	rows.WithLabelValues(s.reconciler.table.Name, rowStateDeleted).Set(float64(len(tableRows) - len(rowUIDs)))
+
+	return rowUIDs, nil
+}
+
+func (s *orphanedRowsSweeper) getInstanceUIDs(ctx context.Context) (map[string]struct{}, error) {
+	instanceList := s.reconciler.createInstanceList()
+
+	if err := s.reconciler.client.List(ctx, instanceList); err != nil {
+		return nil, fmt.Errorf("failed to list the instances on hub: %w", err)
+	}
+
+	instanceUIDs := make(map[string]struct{})
+
+	if err := meta.EachListItem(instanceList, func(object runtime.Object) error {
+		instance, ok := object.(client.Object)
+		if !ok {
+			return fmt.Errorf("%w: %T", errUnexpectedObjectType, object)
+		}
+
+		if !s.reconciler.isInstanceExcluded(instance) {
+			instanceUIDs[string(instance.GetUID())] = struct{}{}
+		}
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to process the instances on hub: %w", err)
+	}
+
+	return instanceUIDs, nil
+}
+
+func (s *orphanedRowsSweeper) markRowsAsDeleted(ctx context.Context, rowUIDs []string) (int, error) {
+	if len(rowUIDs) == 0 {
+		return 0, nil
+	}
+
+	markedRowsCount, err := s.reconciler.markRowsAsDeleted(ctx, specstore.RowFilter{IDs: rowUIDs}, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark orphaned rows as deleted in the database: %w", err)
+	}
+
+	return markedRowsCount, nil
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	policiesv1 "github.com/open-cluster-management/governance-policy-propagator/api/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	testExcludedPolicyUID = "5a6b7c8d-9e0f-4a1b-8c2d-3e4f5a6b7c8d"
+	testOrphanedPolicyUID = "6b7c8d9e-0f1a-4b2c-9d3e-4f5a6b7c8d9e"
+	testDeletedPolicyUID  = "7c8d9e0f-1a2b-4c3d-8e4f-5a6b7c8d9e0f"
+)
+
+func upsertTestRow(t *testing.T, reconciler *genericSpecToDBReconciler, id, name string) {
+	t.Helper()
+
+	if _, err := reconciler.specStore.Upsert(context.Background(), reconciler.table, &specstore.Row{
+		ID:          id,
+		Name:        name,
+		Namespace:   "default",
+		Payload:     []byte("{}"),
+		PayloadHash: name,
+	}, nil); err != nil {
+		t.Fatalf("failed to upsert the row of %s: %v", name, err)
+	}
+}
+
+func TestSweepMarksOrphanedAndExcludedRowsAsDeleted(t *testing.T) {
+	excludedPolicy := &policiesv1.Policy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "excluded",
+			Namespace:   "default",
+			UID:         testExcludedPolicyUID,
+			Annotations: map[string]string{skipSyncAnnotation: "true"},
+		},
+	}
+
+	reconciler := newTestPolicyReconciler(t, specstore.NewInMemorySpecStore(), newTestPolicy("10", false),
+		excludedPolicy)
+	sweeper := &orphanedRowsSweeper{reconciler: reconciler, period: orphanedRowsSweepPeriod}
+
+	upsertTestRow(t, reconciler, testPolicyUID, "policy")
+	upsertTestRow(t, reconciler, testExcludedPolicyUID, "excluded")
+	upsertTestRow(t, reconciler, testOrphanedPolicyUID, "orphaned")
+	upsertTestRow(t, reconciler, testDeletedPolicyUID, "deleted")
+
+	if _, err := reconciler.specStore.MarkDeleted(context.Background(), reconciler.table,
+		specstore.RowFilter{IDs: []string{testDeletedPolicyUID}}, 0); err != nil {
+		t.Fatalf("failed to mark the row as deleted: %v", err)
+	}
+
+	sweeper.sweep(context.Background())
+
+	// the metric counts the rows read by the sweep, before it marks the orphaned rows as deleted
+	if live, deleted := testutil.ToFloat64(rows.WithLabelValues(reconciler.table.Name, rowStateLive)),
+		testutil.ToFloat64(rows.WithLabelValues(reconciler.table.Name, rowStateDeleted)); live != 3 || deleted != 1 {
+		t.Errorf("expected 3 live and 1 deleted rows, got %v live and %v deleted", live, deleted)
+	}
+
+	for id, expectedDeleted := range map[string]bool{
+		testPolicyUID:         false,
+		testExcludedPolicyUID: true,
+		testOrphanedPolicyUID: true,
+		testDeletedPolicyUID:  true,
+	} {
+		row, err := reconciler.specStore.Get(context.Background(), reconciler.table, id)
+		if err != nil {
+			t.Fatalf("failed to get the row %s: %v", id, err)
+		}
+
+		if row.Deleted != expectedDeleted {
+			t.Errorf("expected the row %s of %s to be deleted %t, got %t", id, row.Name, expectedDeleted, row.Deleted)
+		}
+	}
+
+	sweeper.sweep(context.Background())
+
+	if live, deleted := testutil.ToFloat64(rows.WithLabelValues(reconciler.table.Name, rowStateLive)),
+		testutil.ToFloat64(rows.WithLabelValues(reconciler.table.Name, rowStateDeleted)); live != 1 || deleted != 3 {
+		t.Errorf("expected 1 live and 3 deleted rows, got %v live and %v deleted", live, deleted)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// computePayloadHash returns the SHA-256 of the canonical JSON of the (cleaned) instance, hex encoded.
+// the JSON is decoded and encoded again, so that the keys of all the objects, including the ones of embedded raw
+// extensions, are sorted and the formatting of raw extensions is normalized. the numbers are decoded as json.Number, so
+// that they are encoded again as they are, and large numbers that only differ beyond the precision of a float64 do
+// not get the same hash.
+func computePayloadHash(instance client.Object) (string, error) {
+	payload, err := json.Marshal(instance)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal the instance: %w", err)
+	}
+
+	var decodedPayload interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&decodedPayload); err != nil {
+		return "", fmt.Errorf("failed to unmarshal the instance: %w", err)
+	}
+
+	canonicalPayload, err := json.Marshal(decodedPayload)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal the canonical instance: %w", err)
+	}
+
+	hash := sha256.Sum256(canonicalPayload)
+
+	return hex.EncodeToString(hash[:]), nil
+}
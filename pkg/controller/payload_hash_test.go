@@ -0,0 +1,41 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func mustComputeTestPayloadHash(t *testing.T, json string) string {
+	t.Helper()
+
+	instance := &unstructured.Unstructured{}
+	if err := instance.UnmarshalJSON([]byte(json)); err != nil {
+		t.Fatalf("failed to unmarshal the instance %s: %v", json, err)
+	}
+
+	payloadHash, err := computePayloadHash(instance)
+	if err != nil {
+		t.Fatalf("failed to compute the payload hash of %s: %v", json, err)
+	}
+
+	return payloadHash
+}
+
+func TestPayloadHashIgnoresTheOrderOfTheKeys(t *testing.T) {
+	if mustComputeTestPayloadHash(t, `{"apiVersion": "v1", "kind": "Config", "spec": {"a": 1, "b": "c"}}`) !=
+		mustComputeTestPayloadHash(t, `{"kind": "Config", "spec": {"b": "c", "a": 1}, "apiVersion": "v1"}`) {
+		t.Error("expected the same hash of the same payload with other orders of the keys")
+	}
+}
+
+func TestPayloadHashDistinguishesLargeNumbers(t *testing.T) {
+	// 2^53 + 1 and 2^53 are the same float64
+	if mustComputeTestPayloadHash(t, `{"apiVersion": "v1", "kind": "Config", "spec": {"a": 9007199254740993}}`) ==
+		mustComputeTestPayloadHash(t, `{"apiVersion": "v1", "kind": "Config", "spec": {"a": 9007199254740992}}`) {
+		t.Error("expected different hashes of numbers that differ beyond the precision of a float64")
+	}
+}
@@ -6,29 +6,47 @@ package controller
 import (
 	"fmt"
 
-	"github.com/jackc/pgx/v4/pgxpool"
 	clusterv1alpha1 "github.com/open-cluster-management/api/cluster/v1alpha1"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
 	"k8s.io/apimachinery/pkg/api/equality"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
-func addPlacementController(mgr ctrl.Manager, databaseConnectionPool *pgxpool.Pool) error {
+func addPlacementController(mgr ctrl.Manager, specStore specstore.SpecStore, config *Config,
+	outageDetector *databaseOutageDetector) error {
+	reconciler := &genericSpecToDBReconciler{
+		client:             mgr.GetClient(),
+		eventRecorder:      mgr.GetEventRecorderFor(eventRecorderName),
+		specStore:          specStore,
+		log:                ctrl.Log.WithName("placements-spec-syncer"),
+		typeName:           "placements",
+		table:              config.specTable("placements"),
+		finalizerName:      hohCleanupFinalizer,
+		createInstance:     func() client.Object { return &clusterv1alpha1.Placement{} },
+		createInstanceList: func() client.ObjectList { return &clusterv1alpha1.PlacementList{} },
+		cleanStatus:        cleanPlacementStatus,
+		areEqual:           arePlacementsEqual,
+		labelSelector:      config.labelSelector("placements"),
+		namespaceFilters:   config.namespaceFilters("placements", nil),
+		resyncEvents:       make(chan event.GenericEvent),
+	}
+
 	if err := ctrl.NewControllerManagedBy(mgr).
 		For(&clusterv1alpha1.Placement{}).
-		Complete(&genericSpecToDBReconciler{
-			client:                 mgr.GetClient(),
-			databaseConnectionPool: databaseConnectionPool,
-			log:                    ctrl.Log.WithName("placements-spec-syncer"),
-			tableName:              "placements",
-			finalizerName:          hohCleanupFinalizer,
-			createInstance:         func() client.Object { return &clusterv1alpha1.Placement{} },
-			cleanStatus:            cleanPlacementStatus,
-			areEqual:               arePlacementsEqual,
-		}); err != nil {
+		WithEventFilter(reconciler.namespacePredicate()).
+		Watches(&source.Channel{Source: reconciler.resyncEvents}, &handler.EnqueueRequestForObject{}).
+		Complete(reconciler); err != nil {
 		return fmt.Errorf("failed to add placement controller to the manager: %w", err)
 	}
 
+	if err := addDatabaseMaintainers(mgr, reconciler, config, outageDetector); err != nil {
+		return fmt.Errorf("failed to add placement database maintainers to the manager: %w", err)
+	}
+
 	return nil
 }
 
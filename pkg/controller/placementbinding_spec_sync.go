@@ -6,29 +6,47 @@ package controller
 import (
 	"fmt"
 
-	"github.com/jackc/pgx/v4/pgxpool"
 	policiesv1 "github.com/open-cluster-management/governance-policy-propagator/api/v1"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
 	"k8s.io/apimachinery/pkg/api/equality"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
-func addPlacementBindingController(mgr ctrl.Manager, databaseConnectionPool *pgxpool.Pool) error {
+func addPlacementBindingController(mgr ctrl.Manager, specStore specstore.SpecStore, config *Config,
+	outageDetector *databaseOutageDetector) error {
+	reconciler := &genericSpecToDBReconciler{
+		client:             mgr.GetClient(),
+		eventRecorder:      mgr.GetEventRecorderFor(eventRecorderName),
+		specStore:          specStore,
+		log:                ctrl.Log.WithName("placementbindings-spec-syncer"),
+		typeName:           "placementbindings",
+		table:              config.specTable("placementbindings"),
+		finalizerName:      hohCleanupFinalizer,
+		createInstance:     func() client.Object { return &policiesv1.PlacementBinding{} },
+		createInstanceList: func() client.ObjectList { return &policiesv1.PlacementBindingList{} },
+		cleanStatus:        cleanPlacementBindingStatus,
+		areEqual:           arePlacementBindingsEqual,
+		labelSelector:      config.labelSelector("placementbindings"),
+		namespaceFilters:   config.namespaceFilters("placementbindings", nil),
+		resyncEvents:       make(chan event.GenericEvent),
+	}
+
 	if err := ctrl.NewControllerManagedBy(mgr).
 		For(&policiesv1.PlacementBinding{}).
-		Complete(&genericSpecToDBReconciler{
-			client:                 mgr.GetClient(),
-			databaseConnectionPool: databaseConnectionPool,
-			log:                    ctrl.Log.WithName("placementbindings-spec-syncer"),
-			tableName:              "placementbindings",
-			finalizerName:          hohCleanupFinalizer,
-			createInstance:         func() client.Object { return &policiesv1.PlacementBinding{} },
-			cleanStatus:            cleanPlacementBindingStatus,
-			areEqual:               arePlacementBindingsEqual,
-		}); err != nil {
+		WithEventFilter(reconciler.namespacePredicate()).
+		Watches(&source.Channel{Source: reconciler.resyncEvents}, &handler.EnqueueRequestForObject{}).
+		Complete(reconciler); err != nil {
 		return fmt.Errorf("failed to add placement binding controller to the manager: %w", err)
 	}
 
+	if err := addDatabaseMaintainers(mgr, reconciler, config, outageDetector); err != nil {
+		return fmt.Errorf("failed to add placement binding database maintainers to the manager: %w", err)
+	}
+
 	return nil
 }
 
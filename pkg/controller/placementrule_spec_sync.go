@@ -6,29 +6,47 @@ package controller
 import (
 	"fmt"
 
-	"github.com/jackc/pgx/v4/pgxpool"
 	appsv1 "github.com/open-cluster-management/multicloud-operators-placementrule/pkg/apis/apps/v1"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
 	"k8s.io/apimachinery/pkg/api/equality"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
-func addPlacementRuleController(mgr ctrl.Manager, databaseConnectionPool *pgxpool.Pool) error {
+func addPlacementRuleController(mgr ctrl.Manager, specStore specstore.SpecStore, config *Config,
+	outageDetector *databaseOutageDetector) error {
+	reconciler := &genericSpecToDBReconciler{
+		client:             mgr.GetClient(),
+		eventRecorder:      mgr.GetEventRecorderFor(eventRecorderName),
+		specStore:          specStore,
+		log:                ctrl.Log.WithName("placementrules-spec-syncer"),
+		typeName:           "placementrules",
+		table:              config.specTable("placementrules"),
+		finalizerName:      hohCleanupFinalizer,
+		createInstance:     func() client.Object { return &appsv1.PlacementRule{} },
+		createInstanceList: func() client.ObjectList { return &appsv1.PlacementRuleList{} },
+		cleanStatus:        cleanPlacementRuleStatus,
+		areEqual:           arePlacementRulesEqual,
+		labelSelector:      config.labelSelector("placementrules"),
+		namespaceFilters:   config.namespaceFilters("placementrules", nil),
+		resyncEvents:       make(chan event.GenericEvent),
+	}
+
 	if err := ctrl.NewControllerManagedBy(mgr).
 		For(&appsv1.PlacementRule{}).
-		Complete(&genericSpecToDBReconciler{
-			client:                 mgr.GetClient(),
-			databaseConnectionPool: databaseConnectionPool,
-			log:                    ctrl.Log.WithName("placementrules-spec-syncer"),
-			tableName:              "placementrules",
-			finalizerName:          hohCleanupFinalizer,
-			createInstance:         func() client.Object { return &appsv1.PlacementRule{} },
-			cleanStatus:            cleanPlacementRuleStatus,
-			areEqual:               arePlacementRulesEqual,
-		}); err != nil {
+		WithEventFilter(reconciler.namespacePredicate()).
+		Watches(&source.Channel{Source: reconciler.resyncEvents}, &handler.EnqueueRequestForObject{}).
+		Complete(reconciler); err != nil {
 		return fmt.Errorf("failed to add placement rule controller to the manager: %w", err)
 	}
 
+	if err := addDatabaseMaintainers(mgr, reconciler, config, outageDetector); err != nil {
+		return fmt.Errorf("failed to add placement rule database maintainers to the manager: %w", err)
+	}
+
 	return nil
 }
 
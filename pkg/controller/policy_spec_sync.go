@@ -6,30 +6,48 @@ package controller
 import (
 	"fmt"
 
-	"github.com/jackc/pgx/v4/pgxpool"
 	policiesv1 "github.com/open-cluster-management/governance-policy-propagator/api/v1"
 	"github.com/open-cluster-management/governance-policy-propagator/controllers/common"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
 	"k8s.io/apimachinery/pkg/api/equality"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
-func addPolicyController(mgr ctrl.Manager, databaseConnectionPool *pgxpool.Pool) error {
+func addPolicyController(mgr ctrl.Manager, specStore specstore.SpecStore, config *Config,
+	outageDetector *databaseOutageDetector) error {
+	reconciler := &genericSpecToDBReconciler{
+		client:             mgr.GetClient(),
+		eventRecorder:      mgr.GetEventRecorderFor(eventRecorderName),
+		specStore:          specStore,
+		log:                ctrl.Log.WithName("policies-spec-syncer"),
+		typeName:           "policies",
+		table:              config.specTable("policies"),
+		finalizerName:      hohCleanupFinalizer,
+		createInstance:     func() client.Object { return &policiesv1.Policy{} },
+		createInstanceList: func() client.ObjectList { return &policiesv1.PolicyList{} },
+		cleanStatus:        cleanPolicyStatus,
+		areEqual:           arePoliciesEqual,
+		labelSelector:      config.labelSelector("policies"),
+		namespaceFilters:   config.namespaceFilters("policies", nil),
+		resyncEvents:       make(chan event.GenericEvent),
+	}
+
 	if err := ctrl.NewControllerManagedBy(mgr).
 		For(&policiesv1.Policy{}).
-		Complete(&genericSpecToDBReconciler{
-			client:                 mgr.GetClient(),
-			databaseConnectionPool: databaseConnectionPool,
-			log:                    ctrl.Log.WithName("policies-spec-syncer"),
-			tableName:              "policies",
-			finalizerName:          hohCleanupFinalizer,
-			createInstance:         func() client.Object { return &policiesv1.Policy{} },
-			cleanStatus:            cleanPolicyStatus,
-			areEqual:               arePoliciesEqual,
-		}); err != nil {
+		WithEventFilter(reconciler.namespacePredicate()).
+		Watches(&source.Channel{Source: reconciler.resyncEvents}, &handler.EnqueueRequestForObject{}).
+		Complete(reconciler); err != nil {
 		return fmt.Errorf("failed to add policy controller to the manager: %w", err)
 	}
 
+	if err := addDatabaseMaintainers(mgr, reconciler, config, outageDetector); err != nil {
+		return fmt.Errorf("failed to add policy database maintainers to the manager: %w", err)
+	}
+
 	return nil
 }
 
@@ -51,7 +69,7 @@ func arePoliciesEqual(instance1, instance2 client.Object) bool {
 		return false
 	}
 
-	// TODO handle Template comparison later
+	// the templates are compared semantically below
 	policy1WithoutTemplates := policy1.DeepCopy()
 	policy1WithoutTemplates.Spec.PolicyTemplates = nil
 
@@ -60,5 +78,28 @@ func arePoliciesEqual(instance1, instance2 client.Object) bool {
 
 	labelsMatch := equality.Semantic.DeepEqual(instance1.GetLabels(), instance2.GetLabels())
 
-	return common.CompareSpecAndAnnotation(policy1WithoutTemplates, policy2WithoutTemplates) && labelsMatch
+	return common.CompareSpecAndAnnotation(policy1WithoutTemplates, policy2WithoutTemplates) && labelsMatch &&
+		arePolicyTemplatesEqual(policy1.Spec.PolicyTemplates, policy2.Spec.PolicyTemplates)
+}
+
+func arePolicyTemplatesEqual(policyTemplates1, policyTemplates2 []*policiesv1.PolicyTemplate) bool {
+	if len(policyTemplates1) != len(policyTemplates2) {
+		return false
+	}
+
+	for i := range policyTemplates1 {
+		if (policyTemplates1[i] == nil) != (policyTemplates2[i] == nil) {
+			return false
+		}
+
+		if policyTemplates1[i] == nil {
+			continue
+		}
+
+		if !areRawExtensionsEqual(&policyTemplates1[i].ObjectDefinition, &policyTemplates2[i].ObjectDefinition) {
+			return false
+		}
+	}
+
+	return true
 }
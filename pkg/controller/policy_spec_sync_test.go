@@ -0,0 +1,88 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"testing"
+
+	policiesv1 "github.com/open-cluster-management/governance-policy-propagator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newTestPolicyWithTemplates(objectDefinitions ...string) *policiesv1.Policy {
+	policyTemplates := make([]*policiesv1.PolicyTemplate, 0, len(objectDefinitions))
+
+	for _, objectDefinition := range objectDefinitions {
+		policyTemplates = append(policyTemplates, &policiesv1.PolicyTemplate{
+			ObjectDefinition: runtime.RawExtension{Raw: []byte(objectDefinition)},
+		})
+	}
+
+	return &policiesv1.Policy{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "default"},
+		Spec:       policiesv1.PolicySpec{PolicyTemplates: policyTemplates},
+	}
+}
+
+func TestArePolicyTemplatesEqual(t *testing.T) {
+	const objectDefinition = `{"apiVersion": "policy.open-cluster-management.io/v1", "kind": "ConfigurationPolicy",
+		"metadata": {"name": "policy-namespace"}, "spec": {"severity": "low", "remediationAction": "inform"}}`
+
+	testCases := []struct {
+		name              string
+		objectDefinitions []string
+		expectedEqual     bool
+	}{
+		{
+			name:              "same templates",
+			objectDefinitions: []string{objectDefinition},
+			expectedEqual:     true,
+		},
+		{
+			name: "different key order and whitespace",
+			objectDefinitions: []string{`{"spec":{"remediationAction":"inform","severity":"low"},` +
+				`"metadata":{"name":"policy-namespace"},"kind":"ConfigurationPolicy",` +
+				`"apiVersion":"policy.open-cluster-management.io/v1"}`},
+			expectedEqual: true,
+		},
+		{
+			name: "null versus absent fields",
+			objectDefinitions: []string{`{"apiVersion": "policy.open-cluster-management.io/v1",
+				"kind": "ConfigurationPolicy", "metadata": {"name": "policy-namespace", "creationTimestamp": null,
+				"labels": {}}, "spec": {"severity": "low", "remediationAction": "inform", "namespaceSelector": null}}`},
+			expectedEqual: true,
+		},
+		{
+			name: "changed template",
+			objectDefinitions: []string{`{"apiVersion": "policy.open-cluster-management.io/v1",
+				"kind": "ConfigurationPolicy", "metadata": {"name": "policy-namespace"},
+				"spec": {"severity": "high", "remediationAction": "inform"}}`},
+			expectedEqual: false,
+		},
+		{
+			name:              "added template",
+			objectDefinitions: []string{objectDefinition, objectDefinition},
+			expectedEqual:     false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			policy1 := newTestPolicyWithTemplates(objectDefinition)
+			policy2 := newTestPolicyWithTemplates(testCase.objectDefinitions...)
+
+			if equal := arePolicyTemplatesEqual(policy1.Spec.PolicyTemplates,
+				policy2.Spec.PolicyTemplates); equal != testCase.expectedEqual {
+				t.Errorf("expected equal templates %t, got %t", testCase.expectedEqual, equal)
+			}
+
+			if equal := arePoliciesEqual(policy1, policy2); equal != testCase.expectedEqual {
+				t.Errorf("expected equal policies %t, got %t", testCase.expectedEqual, equal)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// areRawExtensionsEqual compares two raw extensions semantically: both are decoded, so that the key order and the
+// formatting of the raw JSON do not matter, and null values and empty objects/lists are dropped, so that defaulted
+// fields (e.g. `creationTimestamp: null` of a serialized object) do not matter either.
+func areRawExtensionsEqual(rawExtension1, rawExtension2 *runtime.RawExtension) bool {
+	normalized1, err1 := normalizeRawExtension(rawExtension1)
+	normalized2, err2 := normalizeRawExtension(rawExtension2)
+
+	if err1 != nil || err2 != nil {
+		return false
+	}
+
+	return equality.Semantic.DeepEqual(normalized1, normalized2)
+}
+
+func normalizeRawExtension(rawExtension *runtime.RawExtension) (interface{}, error) {
+	if rawExtension == nil {
+		return nil, nil
+	}
+
+	raw := rawExtension.Raw
+
+	if len(raw) == 0 && rawExtension.Object != nil {
+		marshaledObject, err := json.Marshal(rawExtension.Object)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal raw extension object: %w", err)
+		}
+
+		raw = marshaledObject
+	}
+
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var decoded interface{}
+
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal raw extension: %w", err)
+	}
+
+	return pruneEmptyValues(decoded), nil
+}
+
+// pruneEmptyValues returns the decoded JSON value without null values and empty objects/lists, nil if nothing is left.
+func pruneEmptyValues(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		for key, fieldValue := range typedValue {
+			if prunedFieldValue := pruneEmptyValues(fieldValue); prunedFieldValue != nil {
+				typedValue[key] = prunedFieldValue
+			} else {
+				delete(typedValue, key)
+			}
+		}
+
+		if len(typedValue) == 0 {
+			return nil
+		}
+
+		return typedValue
+	case []interface{}:
+		if len(typedValue) == 0 {
+			return nil
+		}
+
+		for i, element := range typedValue {
+			typedValue[i] = pruneEmptyValues(element)
+		}
+
+		return typedValue
+	default:
+		return typedValue
+	}
+}
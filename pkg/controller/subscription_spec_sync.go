@@ -6,33 +6,48 @@ package controller
 import (
 	"fmt"
 
-	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
 	"k8s.io/apimachinery/pkg/api/equality"
 	subscriptionsv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
-func addSubscriptionController(mgr ctrl.Manager, databaseConnectionPool *pgxpool.Pool) error {
+func addSubscriptionController(mgr ctrl.Manager, specStore specstore.SpecStore, config *Config,
+	outageDetector *databaseOutageDetector) error {
+	reconciler := &genericSpecToDBReconciler{
+		client:             mgr.GetClient(),
+		eventRecorder:      mgr.GetEventRecorderFor(eventRecorderName),
+		specStore:          specStore,
+		log:                ctrl.Log.WithName("subscriptions-spec-syncer"),
+		typeName:           "subscriptions",
+		table:              config.specTable("subscriptions"),
+		finalizerName:      hohCleanupFinalizer,
+		createInstance:     func() client.Object { return &subscriptionsv1.Subscription{} },
+		createInstanceList: func() client.ObjectList { return &subscriptionsv1.SubscriptionList{} },
+		cleanStatus:        cleanSubscriptionStatus,
+		areEqual:           areSubscriptionsEqual,
+		labelSelector:      config.labelSelector("subscriptions"),
+		namespaceFilters: config.namespaceFilters("subscriptions",
+			&NamespaceFilter{Exclude: []string{openClusterManagementNamespace}}),
+		resyncEvents: make(chan event.GenericEvent),
+	}
+
 	if err := ctrl.NewControllerManagedBy(mgr).
 		For(&subscriptionsv1.Subscription{}).
-		WithEventFilter(predicate.NewPredicateFuncs(func(object client.Object) bool {
-			return object.GetNamespace() != "open-cluster-management"
-		})).
-		Complete(&genericSpecToDBReconciler{
-			client:                 mgr.GetClient(),
-			databaseConnectionPool: databaseConnectionPool,
-			log:                    ctrl.Log.WithName("subscriptions-spec-syncer"),
-			tableName:              "subscriptions",
-			finalizerName:          hohCleanupFinalizer,
-			createInstance:         func() client.Object { return &subscriptionsv1.Subscription{} },
-			cleanStatus:            cleanSubscriptionStatus,
-			areEqual:               areSubscriptionsEqual,
-		}); err != nil {
+		WithEventFilter(reconciler.namespacePredicate()).
+		Watches(&source.Channel{Source: reconciler.resyncEvents}, &handler.EnqueueRequestForObject{}).
+		Complete(reconciler); err != nil {
 		return fmt.Errorf("failed to add subscription controller to the manager: %w", err)
 	}
 
+	if err := addDatabaseMaintainers(mgr, reconciler, config, outageDetector); err != nil {
+		return fmt.Errorf("failed to add subscription database maintainers to the manager: %w", err)
+	}
+
 	return nil
 }
 
@@ -46,7 +61,6 @@ func cleanSubscriptionStatus(instance client.Object) {
 }
 
 func areSubscriptionsEqual(instance1, instance2 client.Object) bool {
-	// TODO: subscription come out as not equal because of package override field, check if it matters.
 	subscription1, ok1 := instance1.(*subscriptionsv1.Subscription)
 	subscription2, ok2 := instance2.(*subscriptionsv1.Subscription)
 
@@ -54,9 +68,74 @@ func areSubscriptionsEqual(instance1, instance2 client.Object) bool {
 		return false
 	}
 
-	specMatch := equality.Semantic.DeepEqual(subscription1.Spec, subscription2.Spec)
+	// the package overrides and the cluster overrides are raw extensions. the raw JSON of the hub and of the database
+	// differ in formatting and key order even if nothing changed, so they are compared semantically below.
+	spec1WithoutOverrides := subscription1.Spec.DeepCopy()
+	spec1WithoutOverrides.PackageOverrides = nil
+	spec1WithoutOverrides.Overrides = nil
+
+	spec2WithoutOverrides := subscription2.Spec.DeepCopy()
+	spec2WithoutOverrides.PackageOverrides = nil
+	spec2WithoutOverrides.Overrides = nil
+
+	specMatch := equality.Semantic.DeepEqual(spec1WithoutOverrides, spec2WithoutOverrides) &&
+		arePackageOverridesEqual(subscription1.Spec.PackageOverrides, subscription2.Spec.PackageOverrides) &&
+		areClusterOverridesEqual(subscription1.Spec.Overrides, subscription2.Spec.Overrides)
 	annotationsMatch := equality.Semantic.DeepEqual(instance1.GetAnnotations(), instance2.GetAnnotations())
 	labelsMatch := equality.Semantic.DeepEqual(instance1.GetLabels(), instance2.GetLabels())
 
 	return specMatch && annotationsMatch && labelsMatch
 }
+
+func arePackageOverridesEqual(overrides1, overrides2 []*subscriptionsv1.Overrides) bool {
+	if len(overrides1) != len(overrides2) {
+		return false
+	}
+
+	for i := range overrides1 {
+		if (overrides1[i] == nil) != (overrides2[i] == nil) {
+			return false
+		}
+
+		if overrides1[i] == nil {
+			continue
+		}
+
+		if overrides1[i].PackageAlias != overrides2[i].PackageAlias ||
+			overrides1[i].PackageName != overrides2[i].PackageName ||
+			len(overrides1[i].PackageOverrides) != len(overrides2[i].PackageOverrides) {
+			return false
+		}
+
+		for j := range overrides1[i].PackageOverrides {
+			if !areRawExtensionsEqual(&overrides1[i].PackageOverrides[j].RawExtension,
+				&overrides2[i].PackageOverrides[j].RawExtension) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func areClusterOverridesEqual(overrides1, overrides2 []subscriptionsv1.ClusterOverrides) bool {
+	if len(overrides1) != len(overrides2) {
+		return false
+	}
+
+	for i := range overrides1 {
+		if overrides1[i].ClusterName != overrides2[i].ClusterName ||
+			len(overrides1[i].ClusterOverrides) != len(overrides2[i].ClusterOverrides) {
+			return false
+		}
+
+		for j := range overrides1[i].ClusterOverrides {
+			if !areRawExtensionsEqual(&overrides1[i].ClusterOverrides[j].RawExtension,
+				&overrides2[i].ClusterOverrides[j].RawExtension) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"encoding/json"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	subscriptionsv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+)
+
+func newTestSubscription(packageOverride, clusterOverride string) *subscriptionsv1.Subscription {
+	return &subscriptionsv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "subscription", Namespace: "default"},
+		Spec: subscriptionsv1.SubscriptionSpec{
+			Channel: "default/channel",
+			PackageOverrides: []*subscriptionsv1.Overrides{{
+				PackageName: "package",
+				PackageOverrides: []subscriptionsv1.PackageOverride{{
+					RawExtension: runtime.RawExtension{Raw: []byte(packageOverride)},
+				}},
+			}},
+			Overrides: []subscriptionsv1.ClusterOverrides{{
+				ClusterName: "cluster",
+				ClusterOverrides: []subscriptionsv1.ClusterOverride{{
+					RawExtension: runtime.RawExtension{Raw: []byte(clusterOverride)},
+				}},
+			}},
+		},
+	}
+}
+
+func TestAreSubscriptionsEqual(t *testing.T) {
+	const (
+		packageOverride = `{"path": "spec", "value": {"replicas": 2, "image": "nginx"}}`
+		clusterOverride = `{"path": "metadata.labels", "value": {"environment": "dev"}}`
+	)
+
+	testCases := []struct {
+		name            string
+		packageOverride string
+		clusterOverride string
+		expectedEqual   bool
+	}{
+		{
+			name:            "same overrides",
+			packageOverride: packageOverride,
+			clusterOverride: clusterOverride,
+			expectedEqual:   true,
+		},
+		{
+			name:            "different key order and whitespace",
+			packageOverride: `{ "value":{"image":"nginx","replicas":2},"path":"spec" }`,
+			clusterOverride: "{\n  \"value\": {\"environment\": \"dev\"},\n  \"path\": \"metadata.labels\"\n}",
+			expectedEqual:   true,
+		},
+		{
+			name:            "null versus absent fields",
+			packageOverride: `{"path": "spec", "value": {"replicas": 2, "image": "nginx", "resources": null}}`,
+			clusterOverride: `{"path": "metadata.labels", "value": {"environment": "dev"}, "annotations": {}}`,
+			expectedEqual:   true,
+		},
+		{
+			name:            "changed package override",
+			packageOverride: `{"path": "spec", "value": {"replicas": 3, "image": "nginx"}}`,
+			clusterOverride: clusterOverride,
+			expectedEqual:   false,
+		},
+		{
+			name:            "changed cluster override",
+			packageOverride: packageOverride,
+			clusterOverride: `{"path": "metadata.labels", "value": {"environment": "prod"}}`,
+			expectedEqual:   false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			subscription1 := newTestSubscription(packageOverride, clusterOverride)
+			subscription2 := newTestSubscription(testCase.packageOverride, testCase.clusterOverride)
+
+			if equal := areSubscriptionsEqual(subscription1, subscription2); equal != testCase.expectedEqual {
+				t.Errorf("expected equal %t, got %t", testCase.expectedEqual, equal)
+			}
+		})
+	}
+}
+
+// TestSubscriptionDoesNotFlap checks that a subscription equals its copy read back from the database, whose raw JSON
+// is reformatted with sorted keys, so that it is not updated on every reconcile.
+func TestSubscriptionDoesNotFlap(t *testing.T) {
+	subscription := newTestSubscription(`{"path": "spec", "value": {"replicas": 2, "image": "nginx"}}`,
+		`{"value": {"environment": "dev"}, "path": "metadata.labels"}`)
+
+	payload, err := json.Marshal(subscription)
+	if err != nil {
+		t.Fatalf("failed to marshal the subscription: %v", err)
+	}
+
+	// jsonb does not keep the key order and the formatting
+	var decodedPayload interface{}
+	if err := json.Unmarshal(payload, &decodedPayload); err != nil {
+		t.Fatalf("failed to unmarshal the payload: %v", err)
+	}
+
+	if payload, err = json.Marshal(decodedPayload); err != nil {
+		t.Fatalf("failed to marshal the payload: %v", err)
+	}
+
+	subscriptionInTheDatabase := &subscriptionsv1.Subscription{}
+	if err := json.Unmarshal(payload, subscriptionInTheDatabase); err != nil {
+		t.Fatalf("failed to unmarshal the subscription in the database: %v", err)
+	}
+
+	if !areSubscriptionsEqual(subscription, subscriptionInTheDatabase) {
+		t.Error("expected the subscription to equal its copy in the database")
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// the sync status is written in annotations of the instances on hub, since the statuses of the types are owned by
+// their own controllers. the annotations are stripped from the payload.
+const (
+	syncStatusAnnotation            = "hub-of-hubs.open-cluster-management.io/sync-status"
+	syncStatusChangedTimeAnnotation = "hub-of-hubs.open-cluster-management.io/sync-status-changed-time"
+	databaseIDAnnotation            = "hub-of-hubs.open-cluster-management.io/database-id"
+	databaseRevisionAnnotation      = "hub-of-hubs.open-cluster-management.io/database-revision"
+	syncErrorAnnotation             = "hub-of-hubs.open-cluster-management.io/sync-error"
+
+	syncStatusSynced = "Synced"
+	syncStatusFailed = "Failed"
+)
+
+var errFinalizerUpdate = errors.New("failed to update the finalizer")
+
+func getSyncStatusAnnotations() []string {
+	return []string{
+		syncStatusAnnotation, syncStatusChangedTimeAnnotation, databaseIDAnnotation, databaseRevisionAnnotation,
+		syncErrorAnnotation,
+	}
+}
+
+func (r *genericSpecToDBReconciler) setSyncedSyncStatus(ctx context.Context, instance client.Object, instanceUID string,
+	revision int64, log logr.Logger) error {
+	return r.patchSyncStatus(ctx, instance, map[string]string{
+		syncStatusAnnotation:       syncStatusSynced,
+		databaseIDAnnotation:       instanceUID,
+		databaseRevisionAnnotation: strconv.FormatInt(revision, 10),
+		syncErrorAnnotation:        "",
+	}, log)
+}
+
+// setFailedSyncStatus records the reason of the sync error in the sync error annotation, rather than the error itself,
+// so that the annotation does not change, and trigger another reconcile, on every failure. the error is in the events
+// and the log.
+func (r *genericSpecToDBReconciler) setFailedSyncStatus(ctx context.Context, instance client.Object, syncError error,
+	log logr.Logger) error {
+	return r.patchSyncStatus(ctx, instance, map[string]string{
+		syncStatusAnnotation: syncStatusFailed,
+		syncErrorAnnotation:  getSyncErrorReason(syncError),
+	}, log)
+}
+
+// getSyncErrorReason returns the reason of the sync error, the reason of its event.
+func getSyncErrorReason(syncError error) string {
+	if errors.Is(syncError, errFinalizerUpdate) {
+		return eventReasonFinalizerError
+	}
+
+	return eventReasonDatabaseError
+}
+
+// setFailedSyncStatusOnError sets the failed sync status of the instance if the sync failed, and returns the error of
+// the sync.
+func (r *genericSpecToDBReconciler) setFailedSyncStatusOnError(ctx context.Context, instance client.Object,
+	syncError error, log logr.Logger) error {
+	if syncError == nil {
+		return nil
+	}
+
+	if err := r.setFailedSyncStatus(ctx, instance, syncError, log); err != nil {
+		log.Error(err, "Failed to update the sync status")
+	}
+
+	return syncError
+}
+
+// patchSyncStatus patches the given sync status annotations of the instance, an empty value removes an annotation.
+// the instance is patched only if any of the annotations changes, together with the time of the change, so the
+// reconcile triggered by the patch does not patch the instance again.
+func (r *genericSpecToDBReconciler) patchSyncStatus(ctx context.Context, instance client.Object,
+	syncStatus map[string]string, log logr.Logger) error {
+	annotations := instance.GetAnnotations()
+	changed := false
+
+	for annotation, value := range syncStatus {
+		if annotations[annotation] != value {
+			changed = true
+			break
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	originalInstance, ok := instance.DeepCopyObject().(client.Object)
+	if !ok {
+		panic("wrong instance passed to patchSyncStatus: its deep copy is not a client.Object")
+	}
+
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+
+	for annotation, value := range syncStatus {
+		if value == "" {
+			delete(annotations, annotation)
+		} else {
+			annotations[annotation] = value
+		}
+	}
+
+	annotations[syncStatusChangedTimeAnnotation] = time.Now().UTC().Format(time.RFC3339)
+
+	instance.SetAnnotations(annotations)
+
+	log.Info("Updating the sync status", "status", syncStatus[syncStatusAnnotation])
+
+	if err := r.client.Patch(ctx, instance, client.MergeFrom(originalInstance)); err != nil {
+		return fmt.Errorf("failed to patch the sync status: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// tombstonesCollector periodically hard-deletes the rows of a table that have been marked as deleted for longer than
+// the retention period of the table, with their history, once all the consumers of the table have acknowledged them, if
+// required.
+type tombstonesCollector struct {
+	reconciler             *genericSpecToDBReconciler
+	retention              time.Duration
+	requireAcknowledgement bool
+	period                 time.Duration
+}
+
+func addTombstonesCollector(mgr ctrl.Manager, reconciler *genericSpecToDBReconciler, config *Config) error {
+	retention := config.tombstoneRetention(reconciler.typeName)
+	if retention <= 0 {
+		return nil // the tombstones of the table are kept forever
+	}
+
+	if err := mgr.Add(&tombstonesCollector{
+		reconciler:             reconciler,
+		retention:              retention,
+		requireAcknowledgement: config.TombstoneAcknowledgementRequired,
+		period:                 config.TombstoneCollectionPeriod,
+	}); err != nil {
+		return fmt.Errorf("failed to add tombstones collector: %w", err)
+	}
+
+	return nil
+}
+
+// Start runs the collections until the context is done. the collector requires leader election, like the controllers.
+func (c *tombstonesCollector) Start(ctx context.Context) error {
+	wait.UntilWithContext(ctx, c.collect, c.period)
+
+	return nil
+}
+
+func (c *tombstonesCollector) collect(ctx context.Context) {
+	log := c.reconciler.log.WithValues("table", c.reconciler.table.Name)
+
+	purgedCount, unacknowledgedCount, err := c.reconciler.specStore.PurgeTombstones(ctx, c.reconciler.table,
+		c.retention, c.requireAcknowledgement)
+	if err != nil {
+		log.Error(err, "Tombstones collection failed")
+		return
+	}
+
+	purgedTombstones.WithLabelValues(c.reconciler.table.Name).Add(float64(purgedCount))
+
+	unacknowledgedTombstones.WithLabelValues(c.reconciler.table.Name).Set(float64(unacknowledgedCount))
+
+	log.Info("Tombstones collection complete", "retention", c.retention, "purged rows", purgedCount,
+		"rows waiting for acknowledgement", unacknowledgedCount)
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// runnablesManager records the runnables added to it instead of running them.
+type runnablesManager struct {
+	ctrl.Manager
+	runnables []manager.Runnable
+}
+
+func (m *runnablesManager) Add(runnable manager.Runnable) error {
+	m.runnables = append(m.runnables, runnable)
+	return nil
+}
+
+// GetCache returns no cache, the runnables are not started.
+func (m *runnablesManager) GetCache() cache.Cache {
+	return nil
+}
+
+func TestTombstonesCollectorIsAddedWithTheRetentionOfItsType(t *testing.T) {
+	reconciler := newTestPolicyReconciler(t, specstore.NewInMemorySpecStore())
+	config := &Config{
+		TombstoneRetentionPerType:        map[string]time.Duration{"subscriptions": time.Hour},
+		TombstoneCollectionPeriod:        time.Minute,
+		TombstoneAcknowledgementRequired: true,
+	}
+
+	mgr := &runnablesManager{}
+
+	// the tombstones of a type without a retention are kept forever
+	if err := addTombstonesCollector(mgr, reconciler, config); err != nil || len(mgr.runnables) != 0 {
+		t.Fatalf("expected no collector, got %d collectors with error %v", len(mgr.runnables), err)
+	}
+
+	config.TombstoneRetentionPerType[reconciler.typeName] = 2 * time.Hour
+
+	if err := addTombstonesCollector(mgr, reconciler, config); err != nil || len(mgr.runnables) != 1 {
+		t.Fatalf("expected a collector, got %d collectors with error %v", len(mgr.runnables), err)
+	}
+
+	collector, ok := mgr.runnables[0].(*tombstonesCollector)
+	if !ok {
+		t.Fatalf("expected a tombstones collector, got %T", mgr.runnables[0])
+	}
+
+	if collector.retention != 2*time.Hour || collector.period != time.Minute || !collector.requireAcknowledgement {
+		t.Errorf("expected a collector of the retention, period and acknowledgement of the config, got %v, %v and %t",
+			collector.retention, collector.period, collector.requireAcknowledgement)
+	}
+}
+
+func TestCollectUpdatesTheTombstonesMetrics(t *testing.T) {
+	reconciler := newTestPolicyReconciler(t, specstore.NewInMemorySpecStore())
+	reconciler.table.Name = "collected_policies"
+
+	upsertTestRow(t, reconciler, testPolicyUID, "policy")
+	upsertTestRow(t, reconciler, testDeletedPolicyUID, "deleted")
+
+	if _, err := reconciler.specStore.MarkDeleted(context.Background(), reconciler.table,
+		specstore.RowFilter{IDs: []string{testDeletedPolicyUID}}, 0); err != nil {
+		t.Fatalf("failed to mark the row as deleted: %v", err)
+	}
+
+	collector := &tombstonesCollector{reconciler: reconciler, retention: time.Nanosecond, requireAcknowledgement: true}
+
+	// the in-memory store has no consumers that acknowledge the tombstone
+	collector.collect(context.Background())
+
+	if purged, unacknowledged := testutil.ToFloat64(purgedTombstones.WithLabelValues(reconciler.table.Name)),
+		testutil.ToFloat64(unacknowledgedTombstones.WithLabelValues(reconciler.table.Name)); purged != 0 ||
+		unacknowledged != 1 {
+		t.Errorf("expected 0 purged and 1 unacknowledged tombstones, got %v and %v", purged, unacknowledged)
+	}
+
+	collector.requireAcknowledgement = false
+	collector.collect(context.Background())
+
+	if purged, unacknowledged := testutil.ToFloat64(purgedTombstones.WithLabelValues(reconciler.table.Name)),
+		testutil.ToFloat64(unacknowledgedTombstones.WithLabelValues(reconciler.table.Name)); purged != 1 ||
+		unacknowledged != 0 {
+		t.Errorf("expected 1 purged and 0 unacknowledged tombstones, got %v and %v", purged, unacknowledged)
+	}
+
+	if _, err := reconciler.specStore.Get(context.Background(), reconciler.table, testPolicyUID); err != nil {
+		t.Errorf("expected the live row to be kept, got %v", err)
+	}
+}
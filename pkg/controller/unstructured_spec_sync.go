@@ -0,0 +1,80 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/specstore"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// addUnstructuredController adds a controller of a type described in the configuration, the instances are reconciled
+// as unstructured objects. no areEqual override is required, the changes are detected by the payload hash.
+func addUnstructuredController(mgr ctrl.Manager, specStore specstore.SpecStore, config *Config,
+	outageDetector *databaseOutageDetector, unstructuredType *UnstructuredType) error {
+	gvk := unstructuredType.groupVersionKind()
+
+	createInstance := func() client.Object {
+		instance := &unstructured.Unstructured{}
+		instance.SetGroupVersionKind(gvk)
+
+		return instance
+	}
+
+	reconciler := &genericSpecToDBReconciler{
+		client:         mgr.GetClient(),
+		eventRecorder:  mgr.GetEventRecorderFor(eventRecorderName),
+		specStore:      specStore,
+		log:            ctrl.Log.WithName(fmt.Sprintf("%s-spec-syncer", unstructuredType.TableName)),
+		typeName:       unstructuredType.TableName,
+		table:          config.specTable(unstructuredType.TableName),
+		finalizerName:  hohCleanupFinalizer,
+		createInstance: createInstance,
+		createInstanceList: func() client.ObjectList {
+			instanceList := &unstructured.UnstructuredList{}
+			instanceList.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+
+			return instanceList
+		},
+		cleanStatus: func(instance client.Object) {
+			cleanUnstructuredStatus(instance, unstructuredType.FieldsToStrip)
+		},
+		labelSelector:    config.labelSelector(unstructuredType.TableName),
+		namespaceFilters: config.namespaceFilters(unstructuredType.TableName, &unstructuredType.Namespaces),
+		resyncEvents:     make(chan event.GenericEvent),
+	}
+
+	if err := ctrl.NewControllerManagedBy(mgr).
+		For(createInstance()).
+		WithEventFilter(reconciler.namespacePredicate()).
+		Watches(&source.Channel{Source: reconciler.resyncEvents}, &handler.EnqueueRequestForObject{}).
+		Complete(reconciler); err != nil {
+		return fmt.Errorf("failed to add %s controller to the manager: %w", gvk, err)
+	}
+
+	if err := addDatabaseMaintainers(mgr, reconciler, config, outageDetector); err != nil {
+		return fmt.Errorf("failed to add %s database maintainers to the manager: %w", gvk, err)
+	}
+
+	return nil
+}
+
+func cleanUnstructuredStatus(instance client.Object, fieldsToStrip [][]string) {
+	unstructuredInstance, ok := instance.(*unstructured.Unstructured)
+	if !ok {
+		panic("wrong instance passed to cleanUnstructuredStatus: not an Unstructured")
+	}
+
+	unstructured.RemoveNestedField(unstructuredInstance.Object, "status")
+
+	for _, fieldToStrip := range fieldsToStrip {
+		unstructured.RemoveNestedField(unstructuredInstance.Object, fieldToStrip...)
+	}
+}
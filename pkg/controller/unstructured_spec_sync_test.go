@@ -0,0 +1,68 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestCleanInstanceOfUnstructuredObject(t *testing.T) {
+	reconciler := &genericSpecToDBReconciler{
+		cleanStatus: func(instance client.Object) {
+			cleanUnstructuredStatus(instance, [][]string{{"spec", "remediationAction"}})
+		},
+	}
+
+	instance := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "policy.open-cluster-management.io/v1",
+		"kind":       "PolicySet",
+		"metadata": map[string]interface{}{
+			"name":            "policyset",
+			"namespace":       "default",
+			"uid":             testPolicyUID,
+			"resourceVersion": "10",
+			"generation":      int64(2),
+			"finalizers":      []interface{}{hohCleanupFinalizer},
+			"managedFields":   []interface{}{map[string]interface{}{"manager": "kubectl"}},
+			"ownerReferences": []interface{}{map[string]interface{}{
+				"apiVersion": "v1", "kind": "ConfigMap", "name": "owner", "uid": testPolicyUID,
+			}},
+			"labels": map[string]interface{}{"environment": "production"},
+			"annotations": map[string]interface{}{
+				"kubectl.kubernetes.io/last-applied-configuration": "{}",
+				syncStatusAnnotation: syncStatusSynced,
+				"description":        "kept",
+			},
+		},
+		"spec": map[string]interface{}{
+			"remediationAction": "enforce",
+			"policies":          []interface{}{"policy"},
+		},
+		"status": map[string]interface{}{"compliant": "Compliant"},
+	}}
+
+	reconciler.cleanInstance(instance)
+
+	expectedObject := map[string]interface{}{
+		"apiVersion": "policy.open-cluster-management.io/v1",
+		"kind":       "PolicySet",
+		"metadata": map[string]interface{}{
+			"name":        "policyset",
+			"namespace":   "default",
+			"labels":      map[string]interface{}{"environment": "production"},
+			"annotations": map[string]interface{}{"description": "kept"},
+		},
+		"spec": map[string]interface{}{
+			"policies": []interface{}{"policy"},
+		},
+	}
+
+	if !reflect.DeepEqual(instance.Object, expectedObject) {
+		t.Errorf("expected the cleaned instance %v, got %v", expectedObject, instance.Object)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// ConnectionPool is a database connection pool whose underlying pgxpool.Pool can be swapped while it is used, e.g.
+// when the database credentials are rotated. the queries started before a swap complete on the previous pool, which
+// is closed once all its connections are released.
+type ConnectionPool struct {
+	mutex sync.RWMutex
+	pool  *pgxpool.Pool
+}
+
+// NewConnectionPool creates a ConnectionPool that wraps the given pool.
+func NewConnectionPool(pool *pgxpool.Pool) *ConnectionPool {
+	return &ConnectionPool{pool: pool}
+}
+
+func (p *ConnectionPool) current() *pgxpool.Pool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.pool
+}
+
+// Swap replaces the underlying pool with the given pool, and closes the previous pool in the background once all its
+// connections are released.
+func (p *ConnectionPool) Swap(pool *pgxpool.Pool) {
+	p.mutex.Lock()
+	previousPool := p.pool
+	p.pool = pool
+	p.mutex.Unlock()
+
+	go previousPool.Close() // blocks until the connections acquired by the in-flight queries are released
+}
+
+// Close closes the underlying pool.
+func (p *ConnectionPool) Close() {
+	p.current().Close()
+}
+
+// Ping acquires a connection from the underlying pool and pings the database.
+func (p *ConnectionPool) Ping(ctx context.Context) error {
+	if err := p.current().Ping(ctx); err != nil {
+		return fmt.Errorf("failed to ping: %w", err)
+	}
+
+	return nil
+}
+
+// BeginFunc starts a transaction on the underlying pool, calls f, and then commits or rolls back the transaction
+// depending on whether f returns an error.
+func (p *ConnectionPool) BeginFunc(ctx context.Context, f func(pgx.Tx) error) error {
+	return p.current().BeginFunc(ctx, f) //nolint:wrapcheck // the errors of f are returned as is
+}
+
+// Exec executes sql on the underlying pool.
+func (p *ConnectionPool) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	return p.current().Exec(ctx, sql, arguments...) //nolint:wrapcheck // a thin wrapper of the pool
+}
+
+// Query sends a query to the underlying pool.
+func (p *ConnectionPool) Query(ctx context.Context, sql string, arguments ...interface{}) (pgx.Rows, error) {
+	return p.current().Query(ctx, sql, arguments...) //nolint:wrapcheck // a thin wrapper of the pool
+}
+
+// QueryRow sends a query that is expected to return at most one row to the underlying pool.
+func (p *ConnectionPool) QueryRow(ctx context.Context, sql string, arguments ...interface{}) pgx.Row {
+	return p.current().QueryRow(ctx, sql, arguments...)
+}
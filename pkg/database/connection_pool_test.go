@@ -0,0 +1,72 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jackc/puddle"
+)
+
+// the pools of the tests do not connect, the port is not listened on.
+const testUnreachableDatabaseURL = "host=127.0.0.1 port=1 user=user dbname=hoh connect_timeout=1"
+
+// connectLazily creates a pool of the credentials without connecting to the database.
+func connectLazily(ctx context.Context, credentials Credentials) (*pgxpool.Pool, error) {
+	config, err := credentials.PoolConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	config.LazyConnect = true
+
+	return pgxpool.ConnectConfig(ctx, config) //nolint:wrapcheck // a test helper
+}
+
+func newTestPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	pool, err := connectLazily(context.Background(), Credentials{URL: testUnreachableDatabaseURL})
+	if err != nil {
+		t.Fatalf("failed to create a pool: %v", err)
+	}
+
+	return pool
+}
+
+func TestSwapReplacesAndClosesThePreviousPool(t *testing.T) {
+	previousPool := newTestPool(t)
+	pool := newTestPool(t)
+
+	connectionPool := NewConnectionPool(previousPool)
+	defer connectionPool.Close()
+
+	connectionPool.Swap(pool)
+
+	if connectionPool.current() != pool {
+		t.Fatal("expected the swapped pool to be used")
+	}
+
+	// the previous pool is closed in the background
+	deadline := time.Now().Add(time.Second)
+
+	for !isTestPoolClosed(previousPool) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the previous pool to be closed")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// isTestPoolClosed returns true if the pool is closed, the connections of an open pool fail since they are refused.
+func isTestPoolClosed(pool *pgxpool.Pool) bool {
+	_, err := pool.Acquire(context.Background())
+
+	return errors.Is(err, puddle.ErrClosedPool)
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	errNoDatabaseURL = errors.New("no database URL is configured")
+	errMissingKey    = errors.New("missing key in the secret")
+)
+
+// CredentialsSource describes where the database URL and optionally the password that replaces the password of the
+// URL are read from. the files and the secret are read on every call of Credentials, so that their changes are picked
+// up.
+type CredentialsSource struct {
+	// URL is used if neither URLFile nor SecretURLKey is set
+	URL string
+	// URLFile is the path of a file that contains the URL, e.g. a mounted secret
+	URLFile string
+	// PasswordFile is the path of a file that contains the password, e.g. a mounted secret
+	PasswordFile string
+	// Secret is the secret that contains the URL in SecretURLKey and the password in SecretPasswordKey
+	Secret            types.NamespacedName
+	SecretURLKey      string
+	SecretPasswordKey string
+	// Client reads Secret, it is required only if Secret is set
+	Client client.Client
+}
+
+// Credentials are the database URL, either a URL or a keyword/value connection string, and the password that replaces
+// its password, if PasswordSet.
+type Credentials struct {
+	URL         string
+	Password    string
+	PasswordSet bool
+}
+
+// PoolConfig parses the URL of the credentials into a pool config, with the password of the credentials if set.
+func (c Credentials) PoolConfig() (*pgxpool.Config, error) {
+	config, err := pgxpool.ParseConfig(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the database URL: %w", err) // the password is redacted by pgx
+	}
+
+	if c.PasswordSet {
+		config.ConnConfig.Password = c.Password
+	}
+
+	return config, nil
+}
+
+// Credentials returns the current database URL, with the current password if a password source is set.
+func (s *CredentialsSource) Credentials(ctx context.Context) (Credentials, error) {
+	var secret *corev1.Secret
+
+	if s.Secret.Name != "" {
+		secret = &corev1.Secret{}
+
+		if err := s.Client.Get(ctx, s.Secret, secret); err != nil {
+			return Credentials{}, fmt.Errorf("failed to get the database secret %s: %w", s.Secret, err)
+		}
+	}
+
+	databaseURL, err := s.readURL(secret)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	password, found, err := s.readPassword(secret)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	return Credentials{URL: databaseURL, Password: password, PasswordSet: found}, nil
+}
+
+// Connect connects to the database with the credentials.
+func Connect(ctx context.Context, credentials Credentials) (*pgxpool.Pool, error) {
+	config, err := credentials.PoolConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := pgxpool.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the database: %w", err)
+	}
+
+	return pool, nil
+}
+
+func (s *CredentialsSource) readURL(secret *corev1.Secret) (string, error) {
+	switch {
+	case s.URLFile != "":
+		return readFile(s.URLFile)
+	case secret != nil && s.SecretURLKey != "":
+		return readSecretKey(secret, s.SecretURLKey)
+	case s.URL != "":
+		return s.URL, nil
+	default:
+		return "", errNoDatabaseURL
+	}
+}
+
+func (s *CredentialsSource) readPassword(secret *corev1.Secret) (string, bool, error) {
+	var (
+		password string
+		err      error
+	)
+
+	switch {
+	case s.PasswordFile != "":
+		password, err = readFile(s.PasswordFile)
+	case secret != nil && s.SecretPasswordKey != "":
+		password, err = readSecretKey(secret, s.SecretPasswordKey)
+	default:
+		return "", false, nil
+	}
+
+	if err != nil {
+		return "", false, err
+	}
+
+	return password, true, nil
+}
+
+func readFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
+
+func readSecretKey(secret *corev1.Secret, key string) (string, error) {
+	value, found := secret.Data[key]
+	if !found {
+		return "", fmt.Errorf("%w: %s/%s %s", errMissingKey, secret.Namespace, secret.Name, key)
+	}
+
+	return strings.TrimSpace(string(value)), nil
+}
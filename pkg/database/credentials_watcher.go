@@ -0,0 +1,73 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// CredentialsWatcher polls a CredentialsSource, and once the database URL or password changes, it connects with the
+// new credentials and swaps the pool of a ConnectionPool. the reconciles in flight complete on the previous pool.
+type CredentialsWatcher struct {
+	pool               *ConnectionPool
+	source             *CredentialsSource
+	currentCredentials Credentials
+	period             time.Duration
+	log                logr.Logger
+	connect            func(ctx context.Context, credentials Credentials) (*pgxpool.Pool, error)
+}
+
+// NewCredentialsWatcher creates a CredentialsWatcher of the pool, which is connected with currentCredentials.
+func NewCredentialsWatcher(pool *ConnectionPool, source *CredentialsSource, currentCredentials Credentials,
+	period time.Duration, log logr.Logger) *CredentialsWatcher {
+	return &CredentialsWatcher{
+		pool:               pool,
+		source:             source,
+		currentCredentials: currentCredentials,
+		period:             period,
+		log:                log,
+		connect:            Connect,
+	}
+}
+
+// Start polls the credentials until the context is done.
+func (w *CredentialsWatcher) Start(ctx context.Context) error {
+	wait.UntilWithContext(ctx, w.check, w.period)
+
+	return nil
+}
+
+// NeedLeaderElection returns false, the pool is used by the health checks of all the replicas.
+func (w *CredentialsWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+func (w *CredentialsWatcher) check(ctx context.Context) {
+	credentials, err := w.source.Credentials(ctx)
+	if err != nil {
+		w.log.Error(err, "Failed to read the database credentials")
+		return
+	}
+
+	if credentials == w.currentCredentials {
+		return
+	}
+
+	pool, err := w.connect(ctx, credentials)
+	if err != nil {
+		// the previous pool is kept, the connection is retried on the next check
+		w.log.Error(err, "Failed to connect to the database with the changed credentials")
+		return
+	}
+
+	w.pool.Swap(pool)
+	w.currentCredentials = credentials
+
+	w.log.Info("Connected to the database with the changed credentials")
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var errTestConnectionRefused = errors.New("the connection is refused")
+
+func TestCredentialsWatcherSwapsThePoolOnceTheCredentialsChange(t *testing.T) {
+	passwordFile := writeTestFile(t, "password", "old")
+	source := &CredentialsSource{URL: testUnreachableDatabaseURL, PasswordFile: passwordFile}
+
+	credentials, err := source.Credentials(context.Background())
+	if err != nil {
+		t.Fatalf("failed to read the credentials: %v", err)
+	}
+
+	previousPool := newTestPool(t)
+	connectionPool := NewConnectionPool(previousPool)
+
+	defer connectionPool.Close()
+
+	watcher := NewCredentialsWatcher(connectionPool, source, credentials, time.Minute, logr.Discard())
+
+	var connectedPasswords []string
+
+	watcher.connect = func(ctx context.Context, credentials Credentials) (*pgxpool.Pool, error) {
+		connectedPasswords = append(connectedPasswords, credentials.Password)
+		return connectLazily(ctx, credentials)
+	}
+
+	// the credentials did not change
+	watcher.check(context.Background())
+
+	if len(connectedPasswords) != 0 || connectionPool.current() != previousPool {
+		t.Fatalf("expected the pool to be kept while the credentials are unchanged, connected with %v",
+			connectedPasswords)
+	}
+
+	if err := os.WriteFile(passwordFile, []byte("new"), 0o600); err != nil {
+		t.Fatalf("failed to rotate the password: %v", err)
+	}
+
+	watcher.check(context.Background())
+
+	if len(connectedPasswords) != 1 || connectedPasswords[0] != "new" {
+		t.Fatalf("expected a connection with the new password, connected with %v", connectedPasswords)
+	}
+
+	if connectionPool.current() == previousPool || connectionPool.current().Config().ConnConfig.Password != "new" {
+		t.Error("expected the pool to be swapped with the pool of the new password")
+	}
+
+	// the new credentials are the current credentials
+	watcher.check(context.Background())
+
+	if len(connectedPasswords) != 1 {
+		t.Errorf("expected no other connection, connected with %v", connectedPasswords)
+	}
+}
+
+func TestCredentialsWatcherKeepsThePoolIfTheConnectionFails(t *testing.T) {
+	passwordFile := writeTestFile(t, "password", "old")
+	source := &CredentialsSource{URL: testUnreachableDatabaseURL, PasswordFile: passwordFile}
+
+	previousPool := newTestPool(t)
+	connectionPool := NewConnectionPool(previousPool)
+
+	defer connectionPool.Close()
+
+	watcher := NewCredentialsWatcher(connectionPool, source, Credentials{URL: testUnreachableDatabaseURL},
+		time.Minute, logr.Discard())
+
+	connects := 0
+
+	watcher.connect = func(context.Context, Credentials) (*pgxpool.Pool, error) {
+		connects++
+		return nil, errTestConnectionRefused
+	}
+
+	for i := 0; i < 2; i++ {
+		watcher.check(context.Background())
+	}
+
+	// the connection is retried on every check
+	if connects != 2 || connectionPool.current() != previousPool {
+		t.Errorf("expected the pool to be kept and 2 connections, got %d connections", connects)
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/go-logr/logr"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+const (
+	schemaMigrationsTable = "schema_migrations"
+	// migrationsLockID is the id of the advisory lock that serializes the migrations of concurrent syncers
+	migrationsLockID = 7260413
+)
+
+var (
+	//go:embed migrations/*.sql
+	migrationsFS embed.FS
+
+	errInvalidMigrationName  = errors.New("invalid migration name, expected <version>_<description>.sql")
+	errSchemaNotMigrated     = errors.New("the schema is not migrated, run the migrate subcommand")
+	errOutdatedSchemaVersion = errors.New("outdated schema version, run the migrate subcommand")
+	errNewerSchemaVersion    = errors.New("schema version newer than the versions known to this syncer")
+	errMissingTable          = errors.New("missing table")
+	errMissingColumn         = errors.New("missing column")
+	errMissingSequence       = errors.New("missing sequence")
+)
+
+// getRequiredColumns returns the columns that the syncer uses, per table suffix, after all the migrations.
+func getRequiredColumns() map[string][]string {
+	return map[string][]string{
+		"": {
+			"id", "name", "namespace", "payload", "payload_hash", "deleted", "deleted_at", "version",
+			"hub_resource_version",
+		},
+		"_history":          {"id", "revision", "version", "operation", "recorded_at", "payload"},
+		"_acknowledgements": {"consumer", "version", "acknowledged_at"},
+	}
+}
+
+// migration is a versioned SQL template that is applied to each table, the template gets the SpecTable of the table.
+type migration struct {
+	version  int
+	name     string
+	template *template.Template
+}
+
+func getMigrations() ([]migration, error) {
+	entries, err := migrationsFS.ReadDir("migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the migrations: %w", err)
+	}
+
+	const nameParts = 2 // the version and the description
+
+	migrations := make([]migration, 0, len(entries))
+
+	for _, entry := range entries {
+		versionAndDescription := strings.SplitN(entry.Name(), "_", nameParts)
+
+		version, err := strconv.Atoi(versionAndDescription[0])
+		if err != nil || len(versionAndDescription) != nameParts {
+			return nil, fmt.Errorf("%w: %s", errInvalidMigrationName, entry.Name())
+		}
+
+		content, err := migrationsFS.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		migrationTemplate, err := template.New(entry.Name()).Parse(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse migration %s: %w", entry.Name(), err)
+		}
+
+		migrations = append(migrations, migration{version: version, name: entry.Name(), template: migrationTemplate})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+
+	return migrations, nil
+}
+
+func schemaMigrationsIdentifier(schema string) string {
+	return pgx.Identifier{schema, schemaMigrationsTable}.Sanitize()
+}
+
+func latestSchemaVersion(migrations []migration) int {
+	if len(migrations) == 0 {
+		return 0
+	}
+
+	return migrations[len(migrations)-1].version
+}
+
+// Migrate applies to each of the tables of the schema the migrations that were not applied to it yet, each in its own
+// transaction.
+func Migrate(ctx context.Context, pool *ConnectionPool, schema string, tables []string, log logr.Logger) error {
+	migrations, err := getMigrations()
+	if err != nil {
+		return err
+	}
+
+	if _, err := pool.Exec(ctx, fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s;
+		CREATE TABLE IF NOT EXISTS %s (
+			table_name text NOT NULL,
+			version integer NOT NULL,
+			applied_at timestamp with time zone NOT NULL DEFAULT now(),
+			PRIMARY KEY (table_name, version)
+		)`, pgx.Identifier{schema}.Sanitize(), schemaMigrationsIdentifier(schema))); err != nil {
+		return fmt.Errorf("failed to create the schema migrations table: %w", err)
+	}
+
+	for _, tableName := range tables {
+		table := SpecTable{Schema: schema, Name: tableName}
+
+		for _, migration := range migrations {
+			applied, err := applyMigration(ctx, pool, table, migration)
+			if err != nil {
+				return fmt.Errorf("failed to apply migration %s to table %s: %w", migration.name, table, err)
+			}
+
+			if applied {
+				log.Info("Applied migration", "table", table, "migration", migration.name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(ctx context.Context, pool *ConnectionPool, table SpecTable, migration migration) (bool, error) {
+	var sql bytes.Buffer
+
+	if err := migration.template.Execute(&sql, table); err != nil {
+		return false, fmt.Errorf("failed to execute the migration template: %w", err)
+	}
+
+	applied := false
+
+	if err := pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", migrationsLockID); err != nil {
+			return fmt.Errorf("failed to lock the migrations: %w", err)
+		}
+
+		var alreadyApplied bool
+
+		if err := tx.QueryRow(ctx, fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE table_name = $1 AND
+			version = $2)`, schemaMigrationsIdentifier(table.Schema)), table.Name,
+			migration.version).Scan(&alreadyApplied); err != nil {
+			return fmt.Errorf("failed to get the applied migrations: %w", err)
+		}
+
+		if alreadyApplied {
+			return nil
+		}
+
+		if _, err := tx.Exec(ctx, sql.String()); err != nil {
+			return fmt.Errorf("failed to execute the migration: %w", err)
+		}
+
+		if _, err := tx.Exec(ctx, fmt.Sprintf("INSERT INTO %s (table_name, version) VALUES ($1, $2)",
+			schemaMigrationsIdentifier(table.Schema)), table.Name, migration.version); err != nil {
+			return fmt.Errorf("failed to record the migration: %w", err)
+		}
+
+		applied = true
+
+		return nil
+	}); err != nil {
+		return false, err
+	}
+
+	return applied, nil
+}
+
+// CheckSchema checks that all the known migrations, and no unknown migrations, were applied to each of the tables of
+// the schema, and that the tables have all the columns that the syncer uses.
+func CheckSchema(ctx context.Context, pool *ConnectionPool, schema string, tables []string) error {
+	migrations, err := getMigrations()
+	if err != nil {
+		return err
+	}
+
+	var migrated bool
+
+	if err := pool.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL",
+		schemaMigrationsIdentifier(schema)).Scan(&migrated); err != nil {
+		return fmt.Errorf("failed to check the schema migrations table: %w", err)
+	}
+
+	if !migrated {
+		return fmt.Errorf("%w: missing table %s.%s", errSchemaNotMigrated, schema, schemaMigrationsTable)
+	}
+
+	latestVersion := latestSchemaVersion(migrations)
+
+	for _, tableName := range tables {
+		table := SpecTable{Schema: schema, Name: tableName}
+
+		var version int
+
+		if err := pool.QueryRow(ctx, fmt.Sprintf("SELECT COALESCE(MAX(version), 0) FROM %s WHERE table_name = $1",
+			schemaMigrationsIdentifier(schema)), table.Name).Scan(&version); err != nil {
+			return fmt.Errorf("failed to get the schema version of table %s: %w", table, err)
+		}
+
+		if version > latestVersion {
+			return fmt.Errorf("%w: table %s has version %d, the latest known version is %d", errNewerSchemaVersion,
+				table, version, latestVersion)
+		}
+
+		if version < latestVersion {
+			return fmt.Errorf("%w: table %s has version %d, the latest version is %d", errOutdatedSchemaVersion,
+				table, version, latestVersion)
+		}
+
+		if err := checkTableColumns(ctx, pool, table); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkTableColumns(ctx context.Context, pool *ConnectionPool, table SpecTable) error {
+	for suffix, columns := range getRequiredColumns() {
+		tableWithSuffix := SpecTable{Schema: table.Schema, Name: table.Name + suffix}
+
+		existingColumns, err := getColumns(ctx, pool, tableWithSuffix)
+		if err != nil {
+			return err
+		}
+
+		if len(existingColumns) == 0 {
+			return fmt.Errorf("%w: %s", errMissingTable, tableWithSuffix)
+		}
+
+		for _, column := range columns {
+			if _, found := existingColumns[column]; !found {
+				return fmt.Errorf("%w: %s.%s", errMissingColumn, tableWithSuffix, column)
+			}
+		}
+	}
+
+	var sequenceExists bool
+
+	if err := pool.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL",
+		table.VersionSequenceIdentifier()).Scan(&sequenceExists); err != nil {
+		return fmt.Errorf("failed to check the version sequence of table %s: %w", table, err)
+	}
+
+	if !sequenceExists {
+		return fmt.Errorf("%w: %s_version_seq", errMissingSequence, table)
+	}
+
+	return nil
+}
+
+func getColumns(ctx context.Context, pool *ConnectionPool, table SpecTable) (map[string]struct{}, error) {
+	rows, err := pool.Query(ctx, `SELECT column_name FROM information_schema.columns WHERE table_schema = $1 AND
+		table_name = $2`, table.Schema, table.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the columns of table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]struct{})
+
+	for rows.Next() {
+		var column string
+
+		if err := rows.Scan(&column); err != nil {
+			return nil, fmt.Errorf("failed to get the columns of table %s: %w", table, err)
+		}
+
+		columns[column] = struct{}{}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get the columns of table %s: %w", table, err)
+	}
+
+	return columns, nil
+}
@@ -0,0 +1,27 @@
+-- creates the table of a type, its version sequence and its history table. the table itself is created only if it
+-- does not exist, so that a table that was created before the migrations, with the id, payload and deleted columns,
+-- is adopted.
+CREATE TABLE IF NOT EXISTS {{.Identifier}} (
+    id uuid NOT NULL,
+    payload jsonb NOT NULL,
+    deleted boolean NOT NULL DEFAULT false
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {{.IDIndexIdentifier}} ON {{.Identifier}} (id);
+
+ALTER TABLE {{.Identifier}} ADD COLUMN payload_hash text;
+
+CREATE SEQUENCE {{.VersionSequenceIdentifier}};
+
+ALTER TABLE {{.Identifier}} ADD COLUMN version bigint NOT NULL
+    DEFAULT nextval({{.VersionSequenceLiteral}});
+
+CREATE TABLE {{.HistoryIdentifier}} (
+    id uuid NOT NULL,
+    revision bigint NOT NULL,
+    version bigint NOT NULL,
+    operation text NOT NULL,
+    recorded_at timestamp with time zone NOT NULL DEFAULT now(),
+    payload jsonb,
+    PRIMARY KEY (id, revision)
+);
@@ -0,0 +1,12 @@
+-- adds the name and the namespace of the instances as indexed columns, so that the rows of an instance are found by
+-- its name without scanning the payloads. the namespace of cluster scoped instances is empty.
+ALTER TABLE {{.Identifier}} ADD COLUMN IF NOT EXISTS name text;
+
+ALTER TABLE {{.Identifier}} ADD COLUMN IF NOT EXISTS namespace text;
+
+UPDATE {{.Identifier}} SET name = payload -> 'metadata' ->> 'name',
+    namespace = COALESCE(payload -> 'metadata' ->> 'namespace', '');
+
+ALTER TABLE {{.Identifier}} ALTER COLUMN name SET NOT NULL, ALTER COLUMN namespace SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS {{.NameAndNamespaceIndexIdentifier}} ON {{.Identifier}} (name, namespace);
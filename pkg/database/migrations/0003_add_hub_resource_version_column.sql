@@ -0,0 +1,3 @@
+-- adds the resource version of the instances on hub, so that writes of stale copies of the instances, e.g. by an old
+-- leader, are reported as conflicts. the resource version of the existing rows is unknown.
+ALTER TABLE {{.Identifier}} ADD COLUMN IF NOT EXISTS hub_resource_version bigint NOT NULL DEFAULT 0;
@@ -0,0 +1,7 @@
+-- creates the table where the consumers of a table, e.g. the leaf hubs, acknowledge the highest version of the table
+-- that they have applied, so that the tombstones can be hard-deleted once all the consumers have applied them.
+CREATE TABLE IF NOT EXISTS {{.AcknowledgementsIdentifier}} (
+    consumer text NOT NULL PRIMARY KEY,
+    version bigint NOT NULL,
+    acknowledged_at timestamp with time zone NOT NULL DEFAULT now()
+);
@@ -0,0 +1,5 @@
+-- adds the time when the rows were marked as deleted, the tombstones are purged once it is older than their retention.
+-- the existing tombstones get the time of the migration, since the time they were marked as deleted is unknown.
+ALTER TABLE {{.Identifier}} ADD COLUMN IF NOT EXISTS deleted_at timestamp with time zone;
+
+UPDATE {{.Identifier}} SET deleted_at = now() WHERE deleted = true AND deleted_at IS NULL;
@@ -0,0 +1,69 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// DefaultSchema is the default schema of the spec tables.
+const DefaultSchema = "spec"
+
+// SpecTable is a table of synced instances in a schema. its methods return the identifiers of the table and of its
+// related objects quoted, so that they can be safely put in SQL statements.
+type SpecTable struct {
+	Schema string
+	Name   string
+}
+
+// String returns the unquoted qualified name of the table, e.g. for logs and errors.
+func (t SpecTable) String() string {
+	return fmt.Sprintf("%s.%s", t.Schema, t.Name)
+}
+
+// Identifier returns the quoted qualified name of the table.
+func (t SpecTable) Identifier() string {
+	return pgx.Identifier{t.Schema, t.Name}.Sanitize()
+}
+
+// HistoryIdentifier returns the quoted qualified name of the history table of the table.
+func (t SpecTable) HistoryIdentifier() string {
+	return pgx.Identifier{t.Schema, t.Name + "_history"}.Sanitize()
+}
+
+// AcknowledgementsIdentifier returns the quoted qualified name of the table where the consumers of the table
+// acknowledge the versions that they have applied.
+func (t SpecTable) AcknowledgementsIdentifier() string {
+	return pgx.Identifier{t.Schema, t.Name + "_acknowledgements"}.Sanitize()
+}
+
+// VersionSequenceIdentifier returns the quoted qualified name of the version sequence of the table.
+func (t SpecTable) VersionSequenceIdentifier() string {
+	return pgx.Identifier{t.Schema, t.Name + "_version_seq"}.Sanitize()
+}
+
+// VersionSequenceLiteral returns the quoted qualified name of the version sequence of the table as a string literal,
+// e.g. for nextval.
+func (t SpecTable) VersionSequenceLiteral() string {
+	return quoteLiteral(t.VersionSequenceIdentifier())
+}
+
+// IDIndexIdentifier returns the quoted name of the unique index of the id column of the table, indexes are created in
+// the schema of their table.
+func (t SpecTable) IDIndexIdentifier() string {
+	return pgx.Identifier{t.Name + "_id_idx"}.Sanitize()
+}
+
+// NameAndNamespaceIndexIdentifier returns the quoted name of the index of the name and the namespace columns of the
+// table.
+func (t SpecTable) NameAndNamespaceIndexIdentifier() string {
+	return pgx.Identifier{t.Name + "_name_namespace_idx"}.Sanitize()
+}
+
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
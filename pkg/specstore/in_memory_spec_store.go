@@ -0,0 +1,240 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package specstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/database"
+)
+
+// inMemoryTable is a spec table with its history, the history keeps the last revision and the time of the last change
+// of each row. like the history table of postgres, the history of a tombstone is purged with it, so the revisions of a
+// row inserted again start from 1.
+type inMemoryTable struct {
+	rows          map[string]*Row
+	revisions     map[string]int64
+	lastChangedAt map[string]time.Time
+	version       int64
+}
+
+// inMemorySpecStore stores the instances in memory, e.g. to run the controllers without a database. it is lost when
+// the process exits.
+type inMemorySpecStore struct {
+	mutex  sync.Mutex
+	tables map[database.SpecTable]*inMemoryTable
+}
+
+// NewInMemorySpecStore creates an empty in-memory SpecStore.
+func NewInMemorySpecStore() SpecStore {
+	return &inMemorySpecStore{tables: make(map[database.SpecTable]*inMemoryTable)}
+}
+
+// getTable returns the table, creating it if needed. the mutex must be locked.
+func (s *inMemorySpecStore) getTable(table database.SpecTable) *inMemoryTable {
+	memoryTable, found := s.tables[table]
+	if !found {
+		memoryTable = &inMemoryTable{
+			rows:          make(map[string]*Row),
+			revisions:     make(map[string]int64),
+			lastChangedAt: make(map[string]time.Time),
+		}
+		s.tables[table] = memoryTable
+	}
+
+	return memoryTable
+}
+
+// recordChange bumps the version and the revision of the row.
+func (t *inMemoryTable) recordChange(row *Row) {
+	t.version++
+	row.Version = t.version
+	t.revisions[row.ID]++
+	row.Revision = t.revisions[row.ID]
+	t.lastChangedAt[row.ID] = time.Now()
+}
+
+func copyRow(row *Row, withPayload bool) *Row {
+	rowCopy := *row
+	rowCopy.Payload = nil
+
+	if withPayload {
+		rowCopy.Payload = append([]byte(nil), row.Payload...)
+	}
+
+	return &rowCopy
+}
+
+func (s *inMemorySpecStore) Get(_ context.Context, table database.SpecTable, id string) (*Row, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	row, found := s.getTable(table).rows[id]
+	if !found {
+		return nil, fmt.Errorf("%w: %s in %s", ErrRowNotFound, id, table)
+	}
+
+	return copyRow(row, true), nil
+}
+
+func (s *inMemorySpecStore) Upsert(_ context.Context, table database.SpecTable, row *Row,
+	isEqual func(existingPayload []byte) (bool, error)) (*UpsertResult, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	memoryTable := s.getTable(table)
+
+	existingRow, found := memoryTable.rows[row.ID]
+	if !found {
+		insertedRow := copyRow(row, true)
+		insertedRow.Deleted = false
+
+		memoryTable.rows[row.ID] = insertedRow
+		memoryTable.recordChange(insertedRow)
+
+		return &UpsertResult{
+			Operation: OperationInsert,
+			Revision:  insertedRow.Revision,
+			MarkedAsDeletedIDs: memoryTable.markDeleted(RowFilter{
+				Name:      row.Name,
+				Namespace: row.Namespace,
+				ExceptID:  row.ID,
+			}, 0),
+		}, nil
+	}
+
+	// the resource versions of hub are opaque, a row with an older hub resource version overwrites a different payload
+	// as a conflict
+	hubResourceVersionConflict := row.HubResourceVersion != 0 &&
+		row.HubResourceVersion < existingRow.HubResourceVersion
+
+	if row.HubResourceVersion != 0 {
+		existingRow.HubResourceVersion = row.HubResourceVersion
+	}
+
+	// a row marked as deleted is always updated, e.g. when its instance is included again after it was excluded
+	if !existingRow.Deleted && existingRow.PayloadHash == row.PayloadHash {
+		return &UpsertResult{Revision: existingRow.Revision}, nil
+	}
+
+	if !existingRow.Deleted && isEqual != nil {
+		equal, err := isEqual(existingRow.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compare the payload of the existing row: %w", err)
+		}
+
+		// only the hash of the equal payload is rewritten, without a new version or revision
+		if equal {
+			existingRow.PayloadHash = row.PayloadHash
+			return &UpsertResult{Revision: existingRow.Revision}, nil
+		}
+	}
+
+	wasDeleted := existingRow.Deleted
+
+	existingRow.Name = row.Name
+	existingRow.Namespace = row.Namespace
+	existingRow.Payload = append([]byte(nil), row.Payload...)
+	existingRow.PayloadHash = row.PayloadHash
+	existingRow.Deleted = false
+	memoryTable.recordChange(existingRow)
+
+	result := &UpsertResult{
+		Operation:                  OperationUpdate,
+		Revision:                   existingRow.Revision,
+		HubResourceVersionConflict: hubResourceVersionConflict,
+	}
+
+	// like an inserted row, a row marked as deleted replaces the rows of the instances with the same name that were
+	// synced in the meantime
+	if wasDeleted {
+		result.MarkedAsDeletedIDs = memoryTable.markDeleted(RowFilter{
+			Name:      row.Name,
+			Namespace: row.Namespace,
+			ExceptID:  row.ID,
+		}, 0)
+	}
+
+	return result, nil
+}
+
+func (s *inMemorySpecStore) MarkDeleted(_ context.Context, table database.SpecTable, filter RowFilter,
+	hubResourceVersion int64) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.getTable(table).markDeleted(filter, hubResourceVersion), nil
+}
+
+func (t *inMemoryTable) markDeleted(filter RowFilter, hubResourceVersion int64) []string {
+	markedRowIDs := make([]string, 0)
+
+	for _, row := range t.rows {
+		if row.Deleted || !filter.matches(row) {
+			continue
+		}
+
+		row.Deleted = true
+		if hubResourceVersion != 0 {
+			row.HubResourceVersion = hubResourceVersion
+		}
+		t.recordChange(row)
+
+		markedRowIDs = append(markedRowIDs, row.ID)
+	}
+
+	return markedRowIDs
+}
+
+func (s *inMemorySpecStore) List(_ context.Context, table database.SpecTable) ([]*Row, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	memoryTable := s.getTable(table)
+	listedRows := make([]*Row, 0, len(memoryTable.rows))
+
+	for _, row := range memoryTable.rows {
+		listedRows = append(listedRows, copyRow(row, false))
+	}
+
+	return listedRows, nil
+}
+
+// PurgeTombstones of the in-memory store never purges a tombstone that requires acknowledgement, since the store has
+// no consumers to acknowledge it.
+func (s *inMemorySpecStore) PurgeTombstones(_ context.Context, table database.SpecTable, retention time.Duration,
+	requireAcknowledgement bool) (int64, int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	memoryTable := s.getTable(table)
+
+	var purgedCount, unacknowledgedCount int64
+
+	for id, row := range memoryTable.rows {
+		if !row.Deleted || time.Since(memoryTable.lastChangedAt[id]) <= retention {
+			continue
+		}
+
+		if requireAcknowledgement {
+			unacknowledgedCount++
+			continue
+		}
+
+		delete(memoryTable.rows, id)
+		delete(memoryTable.revisions, id)
+		delete(memoryTable.lastChangedAt, id)
+
+		purgedCount++
+	}
+
+	return purgedCount, unacknowledgedCount, nil
+}
+
+func (s *inMemorySpecStore) Ping(context.Context) error {
+	return nil
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package specstore
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	metricsNamespace = "hub_of_hubs_spec_sync"
+
+	databaseQuerySelect = "select"
+	databaseQueryInsert = "insert"
+	databaseQueryUpdate = "update"
+	databaseQueryLock   = "lock"
+)
+
+//nolint:gochecknoglobals // the metrics are registered once
+var databaseQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: metricsNamespace,
+	Name:      "database_query_duration_seconds",
+	Help:      "Latency of the database queries of the Postgres spec store, by query type.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"table", "query"})
+
+//nolint:gochecknoinits // the metrics are registered once
+func init() {
+	// register with the registry of controller-runtime, so that the metrics are served by the manager
+	metrics.Registry.MustRegister(databaseQueryDuration)
+}
+
+func observeDatabaseQuery(tableName, query string, startTime time.Time) {
+	databaseQueryDuration.WithLabelValues(tableName, query).Observe(time.Since(startTime).Seconds())
+}
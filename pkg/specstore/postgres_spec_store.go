@@ -0,0 +1,475 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package specstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/database"
+)
+
+// versionsLockClassID is the first key of the per-table advisory locks that serialize the writes of the versions, the
+// second key is the hash of the table. the migrations lock has a single key, so the keys never collide.
+const versionsLockClassID = 7260414
+
+// postgresSpecStore stores the instances in the spec tables of a Postgres database, created by the migrations of the
+// database package.
+type postgresSpecStore struct {
+	databaseConnectionPool *database.ConnectionPool
+}
+
+// NewPostgresSpecStore creates a SpecStore of the Postgres database of the pool.
+func NewPostgresSpecStore(databaseConnectionPool *database.ConnectionPool) SpecStore {
+	return &postgresSpecStore{databaseConnectionPool: databaseConnectionPool}
+}
+
+func (s *postgresSpecStore) Get(ctx context.Context, table database.SpecTable, id string) (*Row, error) {
+	row := &Row{ID: id}
+
+	var payloadHash *string
+
+	queryStartTime := time.Now()
+
+	err := s.databaseConnectionPool.QueryRow(ctx,
+		fmt.Sprintf(`SELECT name, namespace, payload, payload_hash, deleted, version, hub_resource_version,
+			(SELECT COALESCE(MAX(revision), 0) FROM %s WHERE id = $1) FROM %s WHERE id = $1`,
+			table.HistoryIdentifier(), table.Identifier()),
+		id).Scan(&row.Name, &row.Namespace, &row.Payload, &payloadHash, &row.Deleted, &row.Version,
+		&row.HubResourceVersion, &row.Revision)
+
+	observeDatabaseQuery(table.Name, databaseQuerySelect, queryStartTime)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s in %s", ErrRowNotFound, id, table)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the row %s from %s: %w", id, table, err)
+	}
+
+	if payloadHash != nil {
+		row.PayloadHash = *payloadHash
+	}
+
+	return row, nil
+}
+
+// Upsert runs the read-compare-write cycle of the row in a single transaction. the existing row is locked until the
+// transaction ends, so concurrent upserts of the same id (e.g. by an old leader that is still writing and a new one)
+// are serialized, and the one with the newest hub resource version wins whatever the order of their commits.
+func (s *postgresSpecStore) Upsert(ctx context.Context, table database.SpecTable, row *Row,
+	isEqual func(existingPayload []byte) (bool, error)) (*UpsertResult, error) {
+	result := &UpsertResult{}
+
+	if err := s.databaseConnectionPool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if err := lockVersions(ctx, tx, table); err != nil {
+			return err
+		}
+
+		inserted, err := s.insertRowIfNotExists(ctx, tx, table, row, result)
+		if err != nil || inserted {
+			return err
+		}
+
+		return s.updateRowIfChanged(ctx, tx, table, row, isEqual, result)
+	}); err != nil {
+		// wrap the error from an external package, see https://github.com/tomarrell/wrapcheck
+		return nil, fmt.Errorf("failed to upsert the row in %s: %w", table, err)
+	}
+
+	return result, nil
+}
+
+// lockVersions takes the advisory lock of the versions of the table until the transaction ends. the versions are
+// taken from the sequence while the lock is held, so the transactions of the table commit in the order of their
+// versions, and a consumer that has seen a version never misses a lower one that commits later. the lock is taken
+// before any row is locked, so that it never waits for a row lock of a transaction that waits for the advisory lock.
+func lockVersions(ctx context.Context, tx pgx.Tx, table database.SpecTable) error {
+	queryStartTime := time.Now()
+
+	_, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1, hashtext($2))", versionsLockClassID, table.String())
+
+	observeDatabaseQuery(table.Name, databaseQueryLock, queryStartTime)
+
+	if err != nil {
+		return fmt.Errorf("failed to lock the versions of %s: %w", table, err)
+	}
+
+	return nil
+}
+
+// insertRowIfNotExists inserts the row unless a row with its id already exists. a concurrent insert of the same id
+// blocks until the other transaction ends, and then does nothing, so that duplicate key errors never happen.
+func (s *postgresSpecStore) insertRowIfNotExists(ctx context.Context, tx pgx.Tx, table database.SpecTable, row *Row,
+	result *UpsertResult) (bool, error) {
+	queryStartTime := time.Now()
+
+	commandTag, err := tx.Exec(ctx,
+		fmt.Sprintf(`INSERT INTO %s (id,name,namespace,payload,payload_hash,hub_resource_version,version)
+			values($1, $2, $3, $4::jsonb, $5, $6, nextval(%s)) ON CONFLICT (id) DO NOTHING`, table.Identifier(),
+			table.VersionSequenceLiteral()),
+		row.ID, row.Name, row.Namespace, string(row.Payload), row.PayloadHash, row.HubResourceVersion)
+
+	observeDatabaseQuery(table.Name, databaseQueryInsert, queryStartTime)
+
+	if err != nil {
+		return false, fmt.Errorf("insert into database failed: %w", err)
+	}
+
+	if commandTag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if result.Revision, err = recordRevision(ctx, tx, table, row.ID, OperationInsert); err != nil {
+		return false, err
+	}
+
+	result.Operation = OperationInsert
+
+	// the rows of the previous instances with the same name are left behind if an instance is deleted and recreated
+	// with the same name before the deletion is synced
+	if result.MarkedAsDeletedIDs, err = markRowsAsDeleted(ctx, tx, table, RowFilter{
+		Name:      row.Name,
+		Namespace: row.Namespace,
+		ExceptID:  row.ID,
+	}, 0); err != nil {
+		return false, fmt.Errorf("failed to mark previous instances with the same name as deleted: %w", err)
+	}
+
+	return true, nil
+}
+
+// updateRowIfChanged compares the payload hash of the row with the one in the database, and updates the row on
+// mismatch. the payload is fetched from the database only if the hashes differ and isEqual is set, e.g. for rows
+// written before the payload hash was introduced. only the hash of an equal payload is rewritten, without a new
+// version or revision, so that the payload stays the one recorded in the history and the next upserts of the same
+// payload match the hash. a row marked as deleted is always updated, e.g.
+// when its instance is included again after it was excluded. the resource versions of hub are opaque, so a row with an
+// older hub resource version than the one in the database still overwrites it, and the overwrite is reported as a
+// conflict, e.g. if an old leader writes a stale copy after the new leader wrote a newer one, the next reconcile of the
+// new leader writes its copy again.
+func (s *postgresSpecStore) updateRowIfChanged(ctx context.Context, tx pgx.Tx, table database.SpecTable, row *Row,
+	isEqual func(existingPayload []byte) (bool, error), result *UpsertResult) error {
+	var (
+		payloadHashInTheDatabase        *string
+		deletedInTheDatabase            bool
+		hubResourceVersionInTheDatabase int64
+	)
+
+	queryStartTime := time.Now()
+
+	err := tx.QueryRow(ctx,
+		fmt.Sprintf(`SELECT payload_hash, deleted, hub_resource_version,
+			(SELECT COALESCE(MAX(revision), 0) FROM %s WHERE id = $1) FROM %s WHERE id = $1 FOR UPDATE`,
+			table.HistoryIdentifier(), table.Identifier()),
+		row.ID).Scan(&payloadHashInTheDatabase, &deletedInTheDatabase, &hubResourceVersionInTheDatabase,
+		&result.Revision)
+
+	observeDatabaseQuery(table.Name, databaseQuerySelect, queryStartTime)
+
+	if err != nil {
+		return fmt.Errorf("failed to get the payload hash of the row in the database: %w", err)
+	}
+
+	if deletedInTheDatabase {
+		if err := updateRowOnConflict(ctx, tx, table, row, hubResourceVersionInTheDatabase, result); err != nil {
+			return err
+		}
+
+		// like an inserted row, a row marked as deleted replaces the rows of the instances with the same name that were
+		// synced in the meantime
+		if result.MarkedAsDeletedIDs, err = markRowsAsDeleted(ctx, tx, table, RowFilter{
+			Name:      row.Name,
+			Namespace: row.Namespace,
+			ExceptID:  row.ID,
+		}, 0); err != nil {
+			return fmt.Errorf("failed to mark other instances with the same name as deleted: %w", err)
+		}
+
+		return nil
+	}
+
+	if payloadHashInTheDatabase != nil && *payloadHashInTheDatabase == row.PayloadHash {
+		return updateHubResourceVersion(ctx, tx, table, row, hubResourceVersionInTheDatabase)
+	}
+
+	if isEqual != nil {
+		var payloadInTheDatabase []byte
+
+		queryStartTime = time.Now()
+
+		err := tx.QueryRow(ctx, fmt.Sprintf("SELECT payload FROM %s WHERE id = $1", table.Identifier()),
+			row.ID).Scan(&payloadInTheDatabase)
+
+		observeDatabaseQuery(table.Name, databaseQuerySelect, queryStartTime)
+
+		if err != nil {
+			return fmt.Errorf("failed to get the payload of the row in the database: %w", err)
+		}
+
+		equal, err := isEqual(payloadInTheDatabase)
+		if err != nil {
+			return fmt.Errorf("failed to compare the payload of the row in the database: %w", err)
+		}
+
+		if equal {
+			queryStartTime = time.Now()
+
+			_, err := tx.Exec(ctx, fmt.Sprintf(`UPDATE %s SET payload_hash = $1,
+				hub_resource_version = COALESCE(NULLIF($2, 0), hub_resource_version) WHERE id = $3`,
+				table.Identifier()),
+				row.PayloadHash, row.HubResourceVersion, row.ID)
+
+			observeDatabaseQuery(table.Name, databaseQueryUpdate, queryStartTime)
+
+			if err != nil {
+				return fmt.Errorf("failed to update the payload hash in the database: %w", err)
+			}
+
+			return nil
+		}
+	}
+
+	return updateRowOnConflict(ctx, tx, table, row, hubResourceVersionInTheDatabase, result)
+}
+
+// updateRowOnConflict updates the row, and reports a conflict if the hub resource version of the row is older than the
+// one in the database.
+func updateRowOnConflict(ctx context.Context, tx pgx.Tx, table database.SpecTable, row *Row,
+	hubResourceVersionInTheDatabase int64, result *UpsertResult) error {
+	result.HubResourceVersionConflict = row.HubResourceVersion != 0 &&
+		row.HubResourceVersion < hubResourceVersionInTheDatabase
+
+	return updateRow(ctx, tx, table, row, result)
+}
+
+// updateRow writes the row, as not deleted, with a new version and records its update revision.
+func updateRow(ctx context.Context, tx pgx.Tx, table database.SpecTable, row *Row, result *UpsertResult) error {
+	queryStartTime := time.Now()
+
+	_, err := tx.Exec(ctx, fmt.Sprintf(`UPDATE %s SET name = $1, namespace = $2, payload = $3::jsonb,
+		payload_hash = $4, deleted = false, deleted_at = NULL,
+		hub_resource_version = COALESCE(NULLIF($5, 0), hub_resource_version),
+		version = nextval(%s) WHERE id = $6`, table.Identifier(), table.VersionSequenceLiteral()),
+		row.Name, row.Namespace, string(row.Payload), row.PayloadHash, row.HubResourceVersion, row.ID)
+
+	observeDatabaseQuery(table.Name, databaseQueryUpdate, queryStartTime)
+
+	if err != nil {
+		return fmt.Errorf("failed to update the database with new value: %w", err)
+	}
+
+	if result.Revision, err = recordRevision(ctx, tx, table, row.ID, OperationUpdate); err != nil {
+		return err
+	}
+
+	result.Operation = OperationUpdate
+
+	return nil
+}
+
+// updateHubResourceVersion records another hub resource version of an unchanged row, without changing its version.
+func updateHubResourceVersion(ctx context.Context, tx pgx.Tx, table database.SpecTable, row *Row,
+	hubResourceVersionInTheDatabase int64) error {
+	if row.HubResourceVersion == 0 || row.HubResourceVersion == hubResourceVersionInTheDatabase {
+		return nil
+	}
+
+	queryStartTime := time.Now()
+
+	_, err := tx.Exec(ctx, fmt.Sprintf("UPDATE %s SET hub_resource_version = $1 WHERE id = $2", table.Identifier()),
+		row.HubResourceVersion, row.ID)
+
+	observeDatabaseQuery(table.Name, databaseQueryUpdate, queryStartTime)
+
+	if err != nil {
+		return fmt.Errorf("failed to update the hub resource version in the database: %w", err)
+	}
+
+	return nil
+}
+
+func (s *postgresSpecStore) MarkDeleted(ctx context.Context, table database.SpecTable, filter RowFilter,
+	hubResourceVersion int64) ([]string, error) {
+	var markedRowIDs []string
+
+	if err := s.databaseConnectionPool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if err := lockVersions(ctx, tx, table); err != nil {
+			return err
+		}
+
+		var err error
+
+		markedRowIDs, err = markRowsAsDeleted(ctx, tx, table, filter, hubResourceVersion)
+
+		return err
+	}); err != nil {
+		// wrap the error from an external package, see https://github.com/tomarrell/wrapcheck
+		return nil, fmt.Errorf("failed to mark rows as deleted in %s: %w", table, err)
+	}
+
+	return markedRowIDs, nil
+}
+
+// markRowsAsDeleted marks the not deleted rows that match the filter as deleted and records their delete revisions,
+// in the transaction.
+func markRowsAsDeleted(ctx context.Context, tx pgx.Tx, table database.SpecTable, filter RowFilter,
+	hubResourceVersion int64) ([]string, error) {
+	condition, arguments := "name = $3 AND namespace = $4", []interface{}{
+		filter.ExceptID, hubResourceVersion, filter.Name, filter.Namespace,
+	}
+	if filter.IDs != nil {
+		condition, arguments = "id = ANY($3::uuid[])", []interface{}{filter.ExceptID, hubResourceVersion, filter.IDs}
+	}
+
+	queryStartTime := time.Now()
+	defer observeDatabaseQuery(table.Name, databaseQueryUpdate, queryStartTime)
+
+	// an empty ExceptID excepts no row, the ids are compared as text so that it is not parsed as a uuid
+	rows, err := tx.Query(ctx,
+		fmt.Sprintf(`UPDATE %s SET deleted = true, deleted_at = now(),
+			hub_resource_version = COALESCE(NULLIF($2, 0), hub_resource_version), version = nextval(%s)
+			WHERE %s AND id::text <> $1 AND deleted = false RETURNING id`, table.Identifier(),
+			table.VersionSequenceLiteral(), condition), arguments...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update rows as deleted: %w", err)
+	}
+
+	markedRowIDs := make([]string, 0)
+
+	for rows.Next() {
+		var rowID string
+
+		if err := rows.Scan(&rowID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read the id of a row updated as deleted: %w", err)
+		}
+
+		markedRowIDs = append(markedRowIDs, rowID)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to update rows as deleted: %w", err)
+	}
+
+	for _, rowID := range markedRowIDs {
+		if _, err := recordRevision(ctx, tx, table, rowID, OperationDelete); err != nil {
+			return nil, err
+		}
+	}
+
+	return markedRowIDs, nil
+}
+
+// recordRevision appends the current state of the row with the id to the append-only history table of the table
+// (<schema>.<table>_history), as the next revision of the row, and returns the revision. it must be called in the
+// transaction of the write, after the write, so that the history is exactly what was propagated.
+func recordRevision(ctx context.Context, tx pgx.Tx, table database.SpecTable, id, operation string) (int64, error) {
+	var revision int64
+
+	if err := tx.QueryRow(ctx,
+		fmt.Sprintf(`INSERT INTO %[1]s (id,revision,version,operation,recorded_at,payload)
+			SELECT id, COALESCE((SELECT MAX(revision) FROM %[1]s WHERE id = $1), 0) + 1, version, $2,
+			now(), payload FROM %[2]s WHERE id = $1 RETURNING revision`, table.HistoryIdentifier(),
+			table.Identifier()),
+		id, operation).Scan(&revision); err != nil {
+		return 0, fmt.Errorf("failed to record the %s revision in the history: %w", operation, err)
+	}
+
+	return revision, nil
+}
+
+func (s *postgresSpecStore) List(ctx context.Context, table database.SpecTable) ([]*Row, error) {
+	queryStartTime := time.Now()
+	defer observeDatabaseQuery(table.Name, databaseQuerySelect, queryStartTime)
+
+	rows, err := s.databaseConnectionPool.Query(ctx,
+		fmt.Sprintf("SELECT id, name, namespace, payload_hash, deleted, version, hub_resource_version FROM %s",
+			table.Identifier()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the rows of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	listedRows := make([]*Row, 0)
+
+	for rows.Next() {
+		var (
+			row         Row
+			payloadHash *string
+		)
+
+		if err := rows.Scan(&row.ID, &row.Name, &row.Namespace, &payloadHash, &row.Deleted, &row.Version,
+			&row.HubResourceVersion); err != nil {
+			return nil, fmt.Errorf("failed to read a row of %s: %w", table, err)
+		}
+
+		if payloadHash != nil {
+			row.PayloadHash = *payloadHash
+		}
+
+		listedRows = append(listedRows, &row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the rows of %s: %w", table, err)
+	}
+
+	return listedRows, nil
+}
+
+// PurgeTombstones deletes the expired tombstones with their history and counts the unacknowledged ones in a single
+// transaction. a tombstone is acknowledged once its version is not higher than the lowest version in the
+// acknowledgements table.
+func (s *postgresSpecStore) PurgeTombstones(ctx context.Context, table database.SpecTable, retention time.Duration,
+	requireAcknowledgement bool) (int64, int64, error) {
+	expiredTombstonesCondition := "tombstone.deleted = true AND tombstone.deleted_at < now() - $1 * interval '1 second'"
+
+	acknowledgedTombstonesCondition := "true"
+	if requireAcknowledgement {
+		// the versions start from 1, so no tombstone is acknowledged while there are no consumers
+		acknowledgedTombstonesCondition = fmt.Sprintf(
+			"tombstone.version <= COALESCE((SELECT MIN(version) FROM %s), 0)", table.AcknowledgementsIdentifier())
+	}
+
+	var purgedCount, unacknowledgedCount int64
+
+	if err := s.databaseConnectionPool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		// the history of the purged rows is deleted with them, in the same statement
+		if err := tx.QueryRow(ctx, fmt.Sprintf(`WITH purged AS (DELETE FROM %s AS tombstone WHERE %s AND %s
+			RETURNING id), purged_history AS (DELETE FROM %s WHERE id IN (SELECT id FROM purged))
+			SELECT COUNT(*) FROM purged`, table.Identifier(), expiredTombstonesCondition,
+			acknowledgedTombstonesCondition, table.HistoryIdentifier()), retention.Seconds()).Scan(
+			&purgedCount); err != nil {
+			return fmt.Errorf("failed to delete the tombstones: %w", err)
+		}
+
+		if err := tx.QueryRow(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s AS tombstone WHERE %s AND NOT (%s)",
+			table.Identifier(), expiredTombstonesCondition, acknowledgedTombstonesCondition),
+			retention.Seconds()).Scan(&unacknowledgedCount); err != nil {
+			return fmt.Errorf("failed to count the unacknowledged tombstones: %w", err)
+		}
+
+		return nil
+	}); err != nil {
+		// wrap the error from an external package, see https://github.com/tomarrell/wrapcheck
+		return 0, 0, fmt.Errorf("failed to purge the tombstones of %s: %w", table, err)
+	}
+
+	return purgedCount, unacknowledgedCount, nil
+}
+
+func (s *postgresSpecStore) Ping(ctx context.Context) error {
+	if err := s.databaseConnectionPool.Ping(ctx); err != nil {
+		return fmt.Errorf("failed to ping the database: %w", err)
+	}
+
+	return nil
+}
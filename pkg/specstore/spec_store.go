@@ -0,0 +1,108 @@
+// Copyright (c) 2020 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+
+package specstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/stolostron/hub-of-hubs-spec-sync/pkg/database"
+)
+
+// the operations done on the rows, as recorded in their history.
+const (
+	OperationInsert = "insert"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+)
+
+// ErrRowNotFound is returned by Get if the table has no row with the id.
+var ErrRowNotFound = errors.New("row not found")
+
+// Row is a row of a spec table, i.e. a synced instance.
+type Row struct {
+	// ID is the UID of the instance on hub
+	ID string
+	// Namespace is empty for cluster scoped instances
+	Name      string
+	Namespace string
+	// Payload is the JSON of the instance, it is nil in the rows returned by List
+	Payload     []byte
+	PayloadHash string
+	Deleted     bool
+	Version     int64
+	// Revision is the revision of the last change of the row in its history
+	Revision int64
+	// HubResourceVersion is the resource version of the instance on hub, 0 if unknown
+	HubResourceVersion int64
+}
+
+// RowFilter selects rows by their ids if IDs is not nil, otherwise by their name and namespace. the row with ExceptID
+// is never selected.
+type RowFilter struct {
+	IDs       []string
+	Name      string
+	Namespace string
+	ExceptID  string
+}
+
+func (filter *RowFilter) matches(row *Row) bool {
+	if row.ID == filter.ExceptID {
+		return false
+	}
+
+	if filter.IDs == nil {
+		return row.Name == filter.Name && row.Namespace == filter.Namespace
+	}
+
+	for _, id := range filter.IDs {
+		if row.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UpsertResult is the result of an upsert.
+type UpsertResult struct {
+	// Operation is OperationInsert, OperationUpdate or empty if the row was up to date
+	Operation string
+	// Revision is the current revision of the row
+	Revision int64
+	// MarkedAsDeletedIDs are the ids of the other rows with the same name and namespace that were marked as deleted
+	MarkedAsDeletedIDs []string
+	// HubResourceVersionConflict is true if the row had a newer hub resource version than the upserted row, and was
+	// overwritten with the different payload of the upserted row
+	HubResourceVersionConflict bool
+}
+
+// SpecStore stores the synced instances in spec tables. every change of a row bumps the version of the row, taken from
+// a monotonic per table sequence, and is recorded as the next revision of the row in its history, atomically. the hub
+// resource version of a row is the one of its last write. the resource versions of hub are opaque, so they never order
+// the writes: a write of an older hub resource version is applied and reported as a conflict.
+type SpecStore interface {
+	// Get returns the row with the id, or ErrRowNotFound.
+	Get(ctx context.Context, table database.SpecTable, id string) (*Row, error)
+	// Upsert inserts the row unless a row with its id exists. otherwise it updates the existing row, unless its payload
+	// hash is unchanged or isEqual, if not nil, returns true for its payload, in which case only its payload hash is
+	// updated. once a row is inserted, or updated from deleted, the not deleted rows with the same name and namespace
+	// but with another id are marked as deleted. concurrent upserts of the same id are serialized.
+	Upsert(ctx context.Context, table database.SpecTable, row *Row,
+		isEqual func(existingPayload []byte) (bool, error)) (*UpsertResult, error)
+	// MarkDeleted marks the not deleted rows that match the filter as deleted, and returns their ids. the hub resource
+	// version of the deleted instance, if set, is recorded in the rows.
+	MarkDeleted(ctx context.Context, table database.SpecTable, filter RowFilter,
+		hubResourceVersion int64) ([]string, error)
+	// List returns all the rows of the table, including the deleted rows, without their payloads.
+	List(ctx context.Context, table database.SpecTable) ([]*Row, error)
+	// PurgeTombstones hard-deletes the rows that were marked as deleted before the retention, and, if acknowledgement
+	// is required, whose version all the consumers of the table have acknowledged, together with their history. it
+	// returns the number of the purged rows and the number of the rows waiting for acknowledgement.
+	PurgeTombstones(ctx context.Context, table database.SpecTable, retention time.Duration,
+		requireAcknowledgement bool) (int64, int64, error)
+	// Ping checks that the store is available.
+	Ping(ctx context.Context) error
+}